@@ -0,0 +1,230 @@
+package dispatch
+
+import (
+	"fmt"
+	"github.com/istomyang/wsevent/log"
+	"github.com/istomyang/wsevent/ws"
+	"sync"
+)
+
+// defaultBindBuffer is ReceiverConfig.BufferSize of Bind by default.
+const defaultBindBuffer = 64
+
+type BindConfig struct {
+	// Receiver configures the Receiver registered for the session. When it is zero,
+	// Bind uses a buffer of 64 with OverflowDisconnect, so a slow session can't stall
+	// the Dispatcher and is closed with ws.ClosePolicyViolation instead.
+	Receiver ReceiverConfig
+
+	// Codec encodes Envelope s of the control protocol, default ws.JSONCodec.
+	Codec ws.Codec
+	// FrameType is the type of frames sent to the session, default ws.TextFrame for JSON.
+	FrameType ws.FrameType
+}
+
+// Binding pushes Message s of a Receiver into a ws.Session as ws.Event s, and serves
+// requests of the control protocol from the session, see ws.Envelope.
+type Binding interface {
+	Receiver() Receiver
+	// Keys returns EventKey s the session subscribes now.
+	Keys() []EventKey
+	// Wait waits until the session ends or Close is called.
+	Wait()
+	// Close unregisters and closes the Receiver, it doesn't close the session.
+	// When the Receiver is closed by others, like OverflowDisconnect, the Binding
+	// closes itself and the session.
+	Close()
+}
+
+// Bind registers a Receiver for session in d, the Receiver is closed when session ends.
+func Bind(d Dispatcher, session ws.Session, config BindConfig) Binding {
+	if config.Codec == nil {
+		config.Codec = ws.JSONCodec
+	}
+	if config.FrameType == 0 {
+		config.FrameType = ws.TextFrame
+	}
+	if config.Receiver.BufferSize == 0 && config.Receiver.Overflow == OverflowBlock {
+		config.Receiver.BufferSize = defaultBindBuffer
+		config.Receiver.Overflow = OverflowDisconnect
+	}
+
+	var b = &binding{
+		dispatcher: d,
+		session:    session,
+		config:     config,
+		receiver:   NewReceiverWithConfig(config.Receiver),
+		done:       make(chan void),
+		pushed:     make(chan void),
+	}
+	d.Register(b.receiver)
+
+	go b.push()
+	go b.pull()
+
+	log.Debug("dispatch-bind: bind session")
+	return b
+}
+
+type binding struct {
+	dispatcher Dispatcher
+	session    ws.Session
+	config     BindConfig
+	receiver   Receiver
+
+	keys []EventKey
+	mut  sync.Mutex
+
+	done      chan void
+	pushed    chan void
+	closeOnce sync.Once
+}
+
+// push sends Message s to session until Receiver is closed, and closes session
+// if Receiver is closed by others than Close.
+func (b *binding) push() {
+	defer close(b.pushed)
+	defer func() {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		b.Close()
+		if b.receiver.Overflows() > 0 {
+			_ = b.session.Close(ws.ClosePolicyViolation, "slow consumer")
+		} else {
+			_ = b.session.Close(ws.CloseNormalClosure, "receiver closed")
+		}
+		log.Debug("dispatch-bind: receiver closed, close session")
+	}()
+	for message := range b.receiver.Get() {
+		data, err := b.config.Codec.Marshal(ws.Envelope{
+			Op: ws.OpEvent,
+			Event: &ws.Event{
+				ID:        message.ID,
+				Key:       message.Key,
+				Data:      message.Data,
+				Headers:   message.Headers,
+				Timestamp: message.Timestamp,
+			},
+		})
+		if err != nil {
+			log.Error("dispatch-bind: encode,", err)
+			continue
+		}
+		if err := b.session.SendFrame(ws.Frame{Type: b.config.FrameType, Data: data}); err != nil {
+			log.Debug("dispatch-bind: session send,", err)
+			go b.Close()
+		}
+	}
+}
+
+// pull serves requests from session until session ends.
+func (b *binding) pull() {
+	defer b.Close()
+	for data := range b.session.Receive() {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+
+		var request ws.Envelope
+		var reply ws.Envelope
+		if err := b.config.Codec.Unmarshal(data, &request); err != nil {
+			reply = ws.Envelope{Op: ws.OpError, Error: err.Error()}
+		} else {
+			reply = b.serve(request)
+		}
+
+		data, err := b.config.Codec.Marshal(reply)
+		if err != nil {
+			log.Error("dispatch-bind: marshal reply,", err)
+			continue
+		}
+		if err := b.session.SendFrame(ws.Frame{Type: b.config.FrameType, Data: data}); err != nil {
+			log.Debug("dispatch-bind: session send,", err)
+			return
+		}
+	}
+}
+
+func (b *binding) serve(request ws.Envelope) ws.Envelope {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	switch request.Op {
+	case ws.OpSubscribe:
+		if err := ws.Authorize(b.session, request.Keys); err != nil {
+			return ws.Envelope{ID: request.ID, Op: ws.OpError, Error: err.Error()}
+		}
+		b.keys = union(b.keys, request.Keys)
+		b.receiver.Update(b.keys)
+	case ws.OpUnsubscribe:
+		b.keys = subtract(b.keys, request.Keys)
+		b.receiver.Update(b.keys)
+	case ws.OpList:
+	case ws.OpPing:
+		return ws.Envelope{ID: request.ID, Op: ws.OpPong}
+	default:
+		return ws.Envelope{ID: request.ID, Op: ws.OpError, Error: fmt.Sprintf("unknown op %q", request.Op)}
+	}
+	return ws.Envelope{ID: request.ID, Op: ws.OpAck, Keys: append([]EventKey{}, b.keys...)}
+}
+
+func (b *binding) Receiver() Receiver {
+	return b.receiver
+}
+
+func (b *binding) Keys() []EventKey {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return append([]EventKey(nil), b.keys...)
+}
+
+func (b *binding) Wait() {
+	<-b.done
+	<-b.pushed
+}
+
+func (b *binding) Close() {
+	b.closeOnce.Do(func() {
+		// done is closed first, so push knows Receiver is closed by Close.
+		close(b.done)
+		b.dispatcher.Unregister(b.receiver)
+		b.receiver.Close()
+		log.Debug("dispatch-bind: close")
+	})
+}
+
+var _ Binding = &binding{}
+
+// union returns keys followed by added ones not in keys.
+func union(keys, added []EventKey) []EventKey {
+	var seen = make(map[EventKey]void, len(keys))
+	var result = make([]EventKey, 0, len(keys)+len(added))
+	for _, key := range append(keys[:len(keys):len(keys)], added...) {
+		if _, has := seen[key]; has {
+			continue
+		}
+		seen[key] = noop
+		result = append(result, key)
+	}
+	return result
+}
+
+// subtract returns keys not in removed.
+func subtract(keys, removed []EventKey) []EventKey {
+	var drop = make(map[EventKey]void, len(removed))
+	for _, key := range removed {
+		drop[key] = noop
+	}
+	var result = make([]EventKey, 0, len(keys))
+	for _, key := range keys {
+		if _, has := drop[key]; !has {
+			result = append(result, key)
+		}
+	}
+	return result
+}
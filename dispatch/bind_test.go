@@ -0,0 +1,232 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/istomyang/wsevent/ws"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeSession is one end of an in-memory ws.Session pair.
+type pipeSession struct {
+	id        string
+	in        chan ws.Frame
+	out       chan ws.Frame
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+// pipe returns two connected ws.Session s, closing either ends both.
+func pipe() (*pipeSession, *pipeSession) {
+	var a, b = make(chan ws.Frame), make(chan ws.Frame)
+	var done = make(chan struct{})
+	var once = &sync.Once{}
+	return &pipeSession{id: "a", in: a, out: b, done: done, closeOnce: once},
+		&pipeSession{id: "b", in: b, out: a, done: done, closeOnce: once}
+}
+
+func (s *pipeSession) ID() string {
+	return s.id
+}
+
+func (s *pipeSession) Attributes() ws.Attributes {
+	return ws.Attributes{}
+}
+
+func (s *pipeSession) Compressed() bool {
+	return false
+}
+
+func (s *pipeSession) Receive() <-chan []byte {
+	var out = make(chan []byte)
+	go func() {
+		defer close(out)
+		for frame := range s.ReceiveFrames() {
+			out <- frame.Data
+		}
+	}()
+	return out
+}
+
+func (s *pipeSession) ReceiveFrames() <-chan ws.Frame {
+	var out = make(chan ws.Frame)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case frame := <-s.in:
+				select {
+				case out <- frame:
+				case <-s.done:
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *pipeSession) Send(data []byte) error {
+	return s.SendFrame(ws.Frame{Type: ws.BinaryFrame, Data: data})
+}
+
+func (s *pipeSession) SendFrame(frame ws.Frame) error {
+	select {
+	case s.out <- frame:
+		return nil
+	case <-s.done:
+		return errors.New("pipe closed")
+	}
+}
+
+func (s *pipeSession) Close(code int, reason string) error {
+	s.close()
+	return nil
+}
+
+func (s *pipeSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *pipeSession) Err() error {
+	select {
+	case <-s.done:
+		return ws.ErrSessionClosed
+	default:
+		return nil
+	}
+}
+
+func (s *pipeSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+var _ ws.Session = &pipeSession{}
+
+func TestBind(t *testing.T) {
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	var src = NewSource()
+	d.Connect(src)
+	d.Run()
+
+	var client, server = pipe()
+	var b = Bind(d, server, BindConfig{})
+	var control = ws.NewControl(client, nil)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := control.Subscribe(ctx, "domain.#", "other.*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := control.Unsubscribe(ctx, "other.*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := control.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := control.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[domain.#]" || fmt.Sprint(b.Keys()) != "[domain.#]" {
+		t.Fatalf("got keys %v and %v, want [domain.#]", keys, b.Keys())
+	}
+
+	src.Send(Message{Key: "other_run", Data: []byte("other")})
+	src.Send(Message{Key: "domain_system_run", Data: []byte(`{"status":"fail"}`), Headers: map[string]string{"tenant": "mine"}})
+	select {
+	case event := <-control.Events():
+		if event.Key != "domain_system_run" || string(event.Data) != `{"status":"fail"}` || event.Headers["tenant"] != "mine" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	server.close()
+	var waited = make(chan void)
+	go func() {
+		b.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		t.Fatal("Binding should end with session")
+	}
+	if n := len(d.(*dispatcher).index.match("domain_system_run")); n != 0 {
+		t.Fatalf("got %d receivers after session ends, want 0", n)
+	}
+	if err := control.Ping(ctx); err == nil {
+		t.Fatal("want an error after session ends")
+	}
+}
+
+// closeRecorder records the close code of a ws.Session.
+type closeRecorder struct {
+	*pipeSession
+	codes chan int
+}
+
+func (s *closeRecorder) Close(code int, reason string) error {
+	select {
+	case s.codes <- code:
+	default:
+	}
+	return s.pipeSession.Close(code, reason)
+}
+
+func TestBindSlowConsumer(t *testing.T) {
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	var src = NewSource()
+	d.Connect(src)
+	d.Run()
+
+	var client, server = pipe()
+	var recorder = &closeRecorder{pipeSession: server, codes: make(chan int, 1)}
+	var b = Bind(d, recorder, BindConfig{Receiver: ReceiverConfig{BufferSize: 1, Overflow: OverflowDisconnect}})
+
+	data, err := ws.JSONCodec.Marshal(ws.Envelope{ID: "1", Op: ws.OpSubscribe, Keys: []string{"domain.#"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendFrame(ws.Frame{Type: ws.TextFrame, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	<-client.in // ack
+
+	// The client doesn't read events until the Receiver overflows.
+	var deadline = time.After(time.Second)
+	for b.Receiver().Overflows() == 0 {
+		src.Send(Message{Key: "domain_system_run", Data: []byte("{}")})
+		select {
+		case <-deadline:
+			t.Fatal("Receiver should overflow")
+		default:
+		}
+	}
+	for {
+		select {
+		case <-client.in:
+			continue
+		case <-client.done:
+		case <-deadline:
+			t.Fatal("session should be closed")
+		}
+		break
+	}
+	if code := <-recorder.codes; code != ws.ClosePolicyViolation {
+		t.Fatalf("got close code %d, want %d", code, ws.ClosePolicyViolation)
+	}
+	b.Wait()
+}
@@ -0,0 +1,219 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/istomyang/wsevent/log"
+	"github.com/istomyang/wsevent/subscribe"
+	"strings"
+	"sync"
+)
+
+// Decoder turns a subscribe.Record into a Message.
+// Metadata of Message left empty is filled from Record.
+type Decoder interface {
+	Decode(record subscribe.Record) (Message, error)
+}
+
+// DecoderFunc adapts a function into Decoder.
+type DecoderFunc func(record subscribe.Record) (Message, error)
+
+func (f DecoderFunc) Decode(record subscribe.Record) (Message, error) {
+	return f(record)
+}
+
+// KeyExtractor gets EventKey from data of a Record.
+type KeyExtractor func(data []byte) (EventKey, error)
+
+// NewDecoder returns a Decoder which keeps data as it is and gets EventKey by extract.
+func NewDecoder(extract KeyExtractor) Decoder {
+	return DecoderFunc(func(record subscribe.Record) (Message, error) {
+		key, err := extract(record.Data)
+		if err != nil {
+			return Message{}, err
+		}
+		return Message{Key: key, Data: record.Data}, nil
+	})
+}
+
+// JSONKey extracts EventKey from a string field of a JSON object, path is separated by '.',
+// like "EventName" or "meta.event".
+func JSONKey(path string) KeyExtractor {
+	var segs = strings.Split(path, ".")
+	return func(data []byte) (EventKey, error) {
+		var message = Message{Data: data}
+		value, has := message.field(segs)
+		if !has {
+			return "", fmt.Errorf("dispatch: no field %s in data", path)
+		}
+		key, ok := value.(string)
+		if !ok || key == "" {
+			return "", fmt.Errorf("dispatch: field %s is not a key: %v", path, value)
+		}
+		return key, nil
+	}
+}
+
+// ErrEmptyKey is reported when a Decoder returns a Message without EventKey.
+var ErrEmptyKey = errors.New("dispatch: decoded message has empty key")
+
+type BridgeConfig struct {
+	Decoder Decoder
+
+	// OnError will call with Record s the Decoder fails on, they are skipped.
+	// Default logs the error.
+	OnError func(err error, record subscribe.Record)
+}
+
+// FromSubscriber adapts a running subscribe.Subscribe into a Source you can Connect,
+// Record s are decoded by decoder, see NewBridge.
+func FromSubscriber(sub subscribe.Subscribe, decoder Decoder) (Source, error) {
+	return NewBridge(sub, BridgeConfig{Decoder: decoder})
+}
+
+// NewBridge adapts a running subscribe.Subscribe into a Source.
+// The Source is closed when sub is closed, so Dispatcher stops reading from it.
+// A Record is acked after Dispatcher has put its Message into Receiver s.
+// The Source is also closed when Dispatcher disconnects it or is closed, a Record not
+// delivered then is not acked, and sub is left to its owner to close.
+// If sub is a subscribe.RecordSubscribe, its metadata is kept in Message.
+func NewBridge(sub subscribe.Subscribe, config BridgeConfig) (Source, error) {
+	if config.Decoder == nil {
+		return nil, errors.New("dispatch: bridge needs a Decoder")
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error, record subscribe.Record) {
+			log.Error("dispatch-bridge: skip undecodable record,", err)
+		}
+	}
+
+	var records <-chan subscribe.Record
+	if rs, ok := sub.(subscribe.RecordSubscribe); ok {
+		ch, err := rs.GetRecords()
+		if err != nil {
+			return nil, err
+		}
+		records = ch
+	} else {
+		ch, err := sub.Get()
+		if err != nil {
+			return nil, err
+		}
+		records = subscribe.Records(ch, "")
+	}
+
+	var b = &bridge{
+		config:      config,
+		messageChan: make(chan Message),
+		done:        make(chan void),
+		stop:        make(chan void),
+	}
+	go b.run(records)
+	return b, nil
+}
+
+type bridge struct {
+	config      BridgeConfig
+	messageChan chan Message
+	done        chan void
+	sendMut     sync.RWMutex
+	// stop is closed by Close to end run.
+	stop     chan void
+	stopOnce sync.Once
+}
+
+func (b *bridge) run(records <-chan subscribe.Record) {
+	defer b.close()
+	for {
+		var record subscribe.Record
+		var ok bool
+		select {
+		case record, ok = <-records:
+			if !ok {
+				log.Debug("dispatch-bridge: subscriber closed")
+				return
+			}
+		case <-b.stop:
+			log.Debug("dispatch-bridge: close")
+			return
+		}
+
+		message, err := b.config.Decoder.Decode(record)
+		if err == nil && message.Key == "" {
+			err = ErrEmptyKey
+		}
+		if err != nil {
+			b.config.OnError(err, record)
+			record.Ack()
+			continue
+		}
+		fill(&message, record)
+		message.ack = record.Ack
+		// A stopped bridge never delivers, even if Dispatcher is still reading.
+		select {
+		case <-b.stop:
+			log.Debug("dispatch-bridge: close, drop an undelivered record")
+			return
+		default:
+		}
+		select {
+		case b.messageChan <- message:
+		case <-b.stop:
+			log.Debug("dispatch-bridge: close, drop an undelivered record")
+			return
+		}
+	}
+}
+
+// fill fills empty metadata of message from record.
+func fill(message *Message, record subscribe.Record) {
+	if message.ID == "" && record.Topic != "" {
+		message.ID = fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = record.Timestamp
+	}
+	if message.Source == "" {
+		message.Source = record.Topic
+	}
+	if message.Headers == nil {
+		message.Headers = record.Headers
+	}
+}
+
+func (b *bridge) close() {
+	close(b.done)
+	b.sendMut.Lock()
+	close(b.messageChan)
+	b.sendMut.Unlock()
+}
+
+// Close stops reading the subscriber, Dispatcher calls it on Disconnect and Close.
+func (b *bridge) Close() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+func (b *bridge) Get() <-chan Message {
+	return b.messageChan
+}
+
+// Send puts message along with ones from Subscribe, it is dropped after Subscribe is closed.
+func (b *bridge) Send(message Message) {
+	b.sendMut.RLock()
+	defer b.sendMut.RUnlock()
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	select {
+	case b.messageChan <- message:
+	case <-b.done:
+	}
+}
+
+var _ Source = &bridge{}
+var _ innerSource = &bridge{}
+var _ closableSource = &bridge{}
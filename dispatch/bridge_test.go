@@ -0,0 +1,189 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"github.com/istomyang/wsevent/subscribe"
+	"testing"
+	"time"
+)
+
+func TestFromSubscriber(t *testing.T) {
+	var publishSend = make(chan []byte)
+	var sub = subscribe.NewFakeSubscriber(subscribe.FakeConfig{PublishSend: publishSend})
+	_ = sub.Run()
+	defer sub.Close()
+
+	var failed = make(chan error, 1)
+	src, err := NewBridge(sub, BridgeConfig{
+		Decoder: NewDecoder(JSONKey("EventName")),
+		OnError: func(err error, record subscribe.Record) {
+			failed <- err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	d.Connect(src)
+	var r = NewReceiverWithConfig(ReceiverConfig{BufferSize: 1})
+	r.Update([]EventKey{"domain.#"})
+	d.Register(r)
+	d.Run()
+
+	publishSend <- []byte(`{"Body":"no key"}`)
+	if err := <-failed; err == nil {
+		t.Fatal("want an error for undecodable record")
+	}
+
+	publishSend <- []byte(`{"EventName":"domain_system_run"}`)
+	select {
+	case msg := <-r.Get():
+		if msg.Key != "domain_system_run" || msg.Source != "fake" || msg.ID == "" || msg.Timestamp.IsZero() {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	close(publishSend)
+	select {
+	case _, ok := <-src.(innerSource).Get():
+		if ok {
+			t.Fatal("Source should be closed after subscriber is closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestNewBridgeWithoutDecoder(t *testing.T) {
+	var sub = subscribe.NewFakeSubscriber(subscribe.FakeConfig{})
+	if _, err := NewBridge(sub, BridgeConfig{}); err == nil {
+		t.Fatal("want an error without Decoder")
+	}
+	_, err := FromSubscriber(sub, DecoderFunc(func(record subscribe.Record) (Message, error) {
+		return Message{}, errors.New("never")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBridgeDisconnect(t *testing.T) {
+	var publishSend = make(chan []byte)
+	var acked = make(chan string, 16)
+	var sub = subscribe.NewFakeSubscriber(subscribe.FakeConfig{
+		PublishSend: publishSend,
+		OnAck: func(record subscribe.Record) {
+			acked <- string(record.Data)
+		},
+	})
+	_ = sub.Run()
+	defer sub.Close()
+
+	src, err := NewBridge(sub, BridgeConfig{Decoder: NewDecoder(JSONKey("EventName"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	d.Connect(src)
+	var r = NewReceiverWithConfig(ReceiverConfig{BufferSize: 1})
+	r.Update([]EventKey{"#"})
+	d.Register(r)
+	d.Run()
+
+	publishSend <- []byte(`{"EventName":"a"}`)
+	<-r.Get()
+	if got := <-acked; got != `{"EventName":"a"}` {
+		t.Fatalf("got ack of %s", got)
+	}
+
+	// The subscriber goes on producing, a record nobody reads is not acked.
+	d.Disconnect(src)
+	var sent = make(chan void)
+	go func() {
+		defer close(sent)
+		select {
+		case publishSend <- []byte(`{"EventName":"b"}`):
+		case <-time.After(time.Millisecond * 100):
+		}
+	}()
+	select {
+	case _, ok := <-src.(innerSource).Get():
+		if ok {
+			t.Fatal("bridge should be closed after Disconnect")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bridge is still running after Disconnect")
+	}
+	<-sent
+	select {
+	case got := <-acked:
+		t.Fatalf("got ack of %s after Disconnect", got)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestBridgeDisconnectInFlight(t *testing.T) {
+	var publishSend = make(chan []byte)
+	var acked = make(chan string, 16)
+	var sub = subscribe.NewFakeSubscriber(subscribe.FakeConfig{
+		PublishSend: publishSend,
+		OnAck: func(record subscribe.Record) {
+			acked <- string(record.Data)
+		},
+	})
+	_ = sub.Run()
+	defer sub.Close()
+
+	src, err := NewBridge(sub, BridgeConfig{Decoder: NewDecoder(JSONKey("EventName"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	d.Connect(src)
+	// An unbuffered OverflowBlock Receiver holds Dispatcher in fanOut until it is read.
+	var r = NewReceiver()
+	r.Update([]EventKey{"#"})
+	d.Register(r)
+	d.Run()
+
+	// a is in fanOut and b is taken from the bridge, waiting for the broker.
+	publishSend <- []byte(`{"EventName":"a"}`)
+	publishSend <- []byte(`{"EventName":"b"}`)
+	select {
+	case got := <-acked:
+		t.Fatalf("got ack of %s before delivery", got)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	d.Disconnect(src)
+	select {
+	case msg := <-r.Get():
+		if msg.Key != "a" {
+			t.Fatalf("got %s, want a", msg.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case got := <-acked:
+		if got != `{"EventName":"a"}` {
+			t.Fatalf("got ack of %s, want a", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a should be acked after delivery")
+	}
+	select {
+	case msg := <-r.Get():
+		t.Fatalf("got %s after Disconnect", msg.Key)
+	case got := <-acked:
+		t.Fatalf("got ack of %s dropped by Disconnect", got)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
@@ -0,0 +1,15 @@
+package dispatch
+
+// ReceiverConfig configures a Receiver, zero value is an unbuffered Receiver with OverflowBlock.
+type ReceiverConfig struct {
+	// BufferSize is the number of Message s kept for a slow reader.
+	BufferSize int
+	Overflow   OverflowPolicy
+
+	// Filter drops Message s this Receiver doesn't want beyond its EventKey s, nil for all.
+	Filter Filter
+
+	// OnOverflow will call with the Message dropped or replaced when the buffer is full.
+	// It is called in Dispatcher 's goroutine and must not block.
+	OnOverflow func(receiver Receiver, dropped Message)
+}
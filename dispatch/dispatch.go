@@ -2,67 +2,116 @@ package dispatch
 
 import (
 	"context"
+	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
+// parallelFanOut is the number of subscribers of one Message above which
+// Dispatcher delivers it in parallel.
+const parallelFanOut = 64
+
 // Dispatcher connects Receiver s and Source.
+// All methods are safe to call while Dispatcher is running.
 type Dispatcher interface {
 	Register(receiver Receiver)
+	// Unregister stops delivering to receiver, it doesn't close receiver, see Receiver.Close.
+	Unregister(receiver Receiver)
 	Connect(source Source)
+	// Disconnect stops reading from source, a Source of NewBridge is closed too.
+	Disconnect(source Source)
 
 	Run()
 	Close()
 }
 
 type dispatcher struct {
-	ctx       context.Context
-	cancel    context.CancelFunc
-	receivers []Receiver
-	sources   []Source
-	broker    chan Message
-	closed    atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	index   *index
+	broker  chan Message
+	closed  atomic.Bool
+	running bool
+	// sources maps a Source to the cancel of its reading goroutine, nil if not running.
+	sources map[Source]context.CancelFunc
+	mut     sync.Mutex
 }
 
 func NewDispatcher(ctx context.Context) Dispatcher {
 	ctx, cancel := context.WithCancel(ctx)
 	var dis = dispatcher{
-		ctx:       ctx,
-		cancel:    cancel,
-		receivers: make([]Receiver, 0),
-		broker:    make(chan Message),
+		ctx:     ctx,
+		cancel:  cancel,
+		index:   newIndex(),
+		broker:  make(chan Message),
+		sources: make(map[Source]context.CancelFunc),
 	}
 	return &dis
 }
 
 func (d *dispatcher) Register(receiver Receiver) {
-	// TODO: reduce slices memory, you can in Run.
-	d.receivers = append(d.receivers, receiver)
+	receiver.(innerReceiver).Bind(d.index)
+}
+
+func (d *dispatcher) Unregister(receiver Receiver) {
+	receiver.(innerReceiver).Bind(nil)
 }
 
 func (d *dispatcher) Connect(source Source) {
-	d.sources = append(d.sources, source)
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if _, has := d.sources[source]; has {
+		return
+	}
+	d.sources[source] = nil
+	if d.running {
+		d.sources[source] = d.read(source)
+	}
+}
+
+func (d *dispatcher) Disconnect(source Source) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	cancel, has := d.sources[source]
+	if !has {
+		return
+	}
+	if cancel != nil {
+		cancel()
+	}
+	if cs, ok := source.(closableSource); ok {
+		cs.Close()
+	}
+	delete(d.sources, source)
 }
 
 func (d *dispatcher) Run() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
 
 	go func() {
-		for msg := range d.broker {
-			for _, r := range d.receivers {
-				r.(innerReceiver).Put(&msg)
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case msg := <-d.broker:
+				var ack = msg.ack
+				msg.ack = nil
+				msg.payload = &payload{}
+				d.fanOut(&msg)
+				if ack != nil {
+					ack()
+				}
 			}
 		}
 	}()
 
-	for _, source := range d.sources {
-		source := source
-		go func() {
-			for msg := range source.(innerSource).Get() {
-				if d.closed.Load() {
-					break
-				}
-				d.broker <- msg
-			}
-		}()
+	for source := range d.sources {
+		d.sources[source] = d.read(source)
 	}
 
 	go func() {
@@ -73,9 +122,69 @@ func (d *dispatcher) Run() {
 	}()
 }
 
+// read forwards Message s from source to broker until source is closed or cancel is called,
+// a Message taken then is dropped without ack.
+func (d *dispatcher) read(source Source) context.CancelFunc {
+	ctx, cancel := context.WithCancel(d.ctx)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-source.(innerSource).Get():
+				if !ok || d.closed.Load() {
+					return
+				}
+				select {
+				case d.broker <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// fanOut delivers msg to its subscribers only, a hot key with many subscribers
+// is split over workers, fanOut returns when all of them have got msg to keep order.
+func (d *dispatcher) fanOut(msg *Message) {
+	var receivers = d.index.match(msg.Key)
+	if len(receivers) < parallelFanOut {
+		for _, r := range receivers {
+			r.Put(msg)
+		}
+		return
+	}
+
+	var workers = runtime.GOMAXPROCS(0)
+	var size = (len(receivers) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(receivers); start += size {
+		var part = receivers[start:min(start+size, len(receivers))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, r := range part {
+				r.Put(msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func (d *dispatcher) Close() {
 	defer d.cancel()
 	d.closed.Store(true)
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	for source := range d.sources {
+		if cs, ok := source.(closableSource); ok {
+			cs.Close()
+		}
+	}
 }
 
 var _ Dispatcher = &dispatcher{}
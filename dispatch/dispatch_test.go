@@ -0,0 +1,128 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherFanOut(t *testing.T) {
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+
+	var src = NewSource()
+	d.Connect(src)
+
+	var keys = [][]EventKey{
+		{"domain.system.*"},
+		{"domain_#"},
+		{"other_system_run"},
+	}
+	var receivers = make([]Receiver, len(keys))
+	for i := range keys {
+		receivers[i] = NewReceiver()
+		receivers[i].Update(keys[i])
+		d.Register(receivers[i])
+	}
+	d.Run()
+
+	var got = make([][]EventKey, len(receivers))
+	var wg sync.WaitGroup
+	for i, r := range receivers {
+		i, r := i, r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case msg := <-r.Get():
+					got[i] = append(got[i], msg.Key)
+				case <-time.After(time.Millisecond * 200):
+					return
+				}
+			}
+		}()
+	}
+
+	src.Send(Message{Key: "domain_system_run"})
+	src.Send(Message{Key: "other_system_run"})
+	src.Send(Message{Key: "domain_system_module_run"})
+	wg.Wait()
+
+	var want = []string{
+		"[domain_system_run]",
+		"[domain_system_run domain_system_module_run]",
+		"[other_system_run]",
+	}
+	for i := range want {
+		if fmt.Sprint(got[i]) != want[i] {
+			t.Errorf("receiver %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDispatcherUpdateAfterRegister(t *testing.T) {
+	var d = NewDispatcher(context.Background()).(*dispatcher)
+	var r = NewReceiver()
+	d.Register(r)
+
+	if n := len(d.index.match("domain_system_run")); n != 0 {
+		t.Fatalf("got %d receivers before Update, want 0", n)
+	}
+
+	r.Update([]EventKey{"domain.#", "domain.system.*"})
+	if n := len(d.index.match("domain_system_run")); n != 1 {
+		t.Fatalf("got %d receivers, want 1", n)
+	}
+
+	r.Update([]EventKey{"other.#"})
+	if n := len(d.index.match("domain_system_run")); n != 0 {
+		t.Fatalf("got %d receivers after Update, want 0", n)
+	}
+}
+
+func TestDispatcherUnregisterWhileRunning(t *testing.T) {
+	var d = NewDispatcher(context.Background())
+	defer d.Close()
+	d.Run()
+
+	var src = NewSource()
+	d.Connect(src)
+
+	var stalled = NewReceiver()
+	stalled.Update([]EventKey{"domain.#"})
+	d.Register(stalled)
+
+	var r = NewReceiver()
+	r.Update([]EventKey{"domain.#"})
+	d.Register(r)
+
+	var done = make(chan void)
+	go func() {
+		defer close(done)
+		for range r.Get() {
+		}
+	}()
+
+	// Nobody reads stalled, Close must release the broker anyway.
+	go src.Send(Message{Key: "domain_system_run"})
+	time.Sleep(time.Millisecond * 50)
+	stalled.Close()
+
+	src.Send(Message{Key: "domain_system_run"})
+	d.Unregister(r)
+	r.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("range over Get should end after Close")
+	}
+
+	d.Disconnect(src)
+	if _, ok := <-stalled.Get(); ok {
+		t.Fatal("closed Receiver should have a closed channel")
+	}
+}
@@ -1 +1,2 @@
+// Package dispatch fans Message s from Source s out to Receiver s by EventKey or pattern.
 package dispatch
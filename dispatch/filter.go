@@ -0,0 +1,131 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Filter decides whether a Message matching EventKey of a Receiver is delivered to it,
+// it runs in Dispatcher before Message is copied into Receiver.
+type Filter interface {
+	Match(message *Message) bool
+}
+
+// FilterFunc adapts a predicate into Filter.
+type FilterFunc func(message *Message) bool
+
+func (f FilterFunc) Match(message *Message) bool {
+	return f(message)
+}
+
+// FieldEquals matches Message s whose Data is a JSON object with value at path,
+// path is separated by '.', like "tenant" or "meta.tenant".
+func FieldEquals(path string, value any) Filter {
+	return FieldIn(path, value)
+}
+
+// FieldIn matches Message s whose Data is a JSON object with one of values at path.
+func FieldIn(path string, values ...any) Filter {
+	var expected = make([]any, 0, len(values))
+	for _, v := range values {
+		// Normalize to what encoding/json decodes, so 1 equals 1.0 and structs equal objects.
+		if normalized, err := normalize(v); err == nil {
+			expected = append(expected, normalized)
+		}
+	}
+	var segs = strings.Split(path, ".")
+	return FilterFunc(func(message *Message) bool {
+		got, has := message.field(segs)
+		if !has {
+			return false
+		}
+		for _, v := range expected {
+			if reflect.DeepEqual(got, v) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// HeaderEquals matches Message s with header name set to value.
+func HeaderEquals(name, value string) Filter {
+	return FilterFunc(func(message *Message) bool {
+		v, has := message.Headers[name]
+		return has && v == value
+	})
+}
+
+// And matches when all filters match.
+func And(filters ...Filter) Filter {
+	return FilterFunc(func(message *Message) bool {
+		for _, f := range filters {
+			if !f.Match(message) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Or matches when any of filters matches.
+func Or(filters ...Filter) Filter {
+	return FilterFunc(func(message *Message) bool {
+		for _, f := range filters {
+			if f.Match(message) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Not inverts filter.
+func Not(filter Filter) Filter {
+	return FilterFunc(func(message *Message) bool {
+		return !filter.Match(message)
+	})
+}
+
+func normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+// payload caches decoded Data of a Message, so it is decoded once for all Receiver s.
+type payload struct {
+	once  sync.Once
+	value any
+}
+
+func (p *payload) decode(data []byte) any {
+	p.once.Do(func() {
+		_ = json.Unmarshal(data, &p.value)
+	})
+	return p.value
+}
+
+func (m *Message) field(segs []string) (any, bool) {
+	var p = m.payload
+	if p == nil {
+		p = &payload{}
+	}
+	var value = p.decode(m.Data)
+	for _, seg := range segs {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[seg]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
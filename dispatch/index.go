@@ -0,0 +1,44 @@
+package dispatch
+
+import "sync"
+
+// index is a reverse index from EventKey patterns to Receiver s interested in them,
+// Dispatcher uses it to deliver a Message only to its subscribers.
+type index struct {
+	patterns *trie[innerReceiver]
+	mut      sync.RWMutex
+}
+
+func newIndex() *index {
+	return &index{patterns: newTrie[innerReceiver]()}
+}
+
+// replace moves receiver from oldKeys to newKeys.
+func (i *index) replace(receiver innerReceiver, oldKeys, newKeys []EventKey) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	for _, key := range oldKeys {
+		i.patterns.remove(key, receiver)
+	}
+	for _, key := range newKeys {
+		i.patterns.insert(key, receiver)
+	}
+}
+
+// match returns Receiver s whose patterns match key, each one once.
+func (i *index) match(key EventKey) []innerReceiver {
+	var seen = make(map[innerReceiver]void)
+	var receivers = make([]innerReceiver, 0)
+
+	i.mut.RLock()
+	i.patterns.match(key, func(r innerReceiver) bool {
+		if _, has := seen[r]; !has {
+			seen[r] = noop
+			receivers = append(receivers, r)
+		}
+		return true
+	})
+	i.mut.RUnlock()
+
+	return receivers
+}
@@ -1,6 +1,24 @@
 package dispatch
 
+import "time"
+
 type Message struct {
 	Key  EventKey
 	Data []byte
+
+	// ID identifies Message, like "topic/partition/offset" from Kafka.
+	ID string
+	// Timestamp is when Message is produced.
+	Timestamp time.Time
+	// Source names where Message comes from, like a Kafka topic.
+	Source string
+	// Headers carries metadata like trace ID, content type or tenant.
+	// Headers is shared by all Receiver s of Message, don't modify it.
+	Headers map[string]string
+
+	// payload is set by Dispatcher to share decoded Data between Filter s.
+	payload *payload
+	// ack is set by a bridge to ack the Record of Message, Dispatcher calls it
+	// after fanOut, so a Message dropped before is not acked.
+	ack func()
 }
@@ -0,0 +1,77 @@
+package dispatch
+
+import "sync"
+
+// OverflowPolicy decides what a Receiver does when its reader is slower than Source s
+// and its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the reader, it stalls the Dispatcher and every other Receiver.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the Message being delivered.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest buffered Message to make room.
+	OverflowDropOldest
+	// OverflowCoalesce keeps only the latest Message per EventKey, and drops the
+	// oldest EventKey when BufferSize distinct keys are pending.
+	OverflowCoalesce
+	// OverflowDisconnect closes the Receiver, see Receiver.Close.
+	OverflowDisconnect
+)
+
+// coalesceQueue keeps the latest Message per EventKey in arrival order of keys.
+type coalesceQueue struct {
+	keys    []EventKey
+	pending map[EventKey]Message
+	size    int
+	// signal wakes up the reader of queue.
+	signal chan void
+	mut    sync.Mutex
+}
+
+func newCoalesceQueue(size int) *coalesceQueue {
+	return &coalesceQueue{
+		pending: make(map[EventKey]Message),
+		size:    max(size, 1),
+		signal:  make(chan void, 1),
+	}
+}
+
+// push adds message, and returns the Message replaced or dropped, if any.
+func (q *coalesceQueue) push(message Message) (dropped Message, overflow bool) {
+	q.mut.Lock()
+	if old, has := q.pending[message.Key]; has {
+		dropped, overflow = old, true
+	} else {
+		if len(q.keys) >= q.size {
+			var oldest = q.keys[0]
+			dropped, overflow = q.pending[oldest], true
+			delete(q.pending, oldest)
+			q.keys = q.keys[1:]
+		}
+		q.keys = append(q.keys, message.Key)
+	}
+	q.pending[message.Key] = message
+	q.mut.Unlock()
+
+	select {
+	case q.signal <- noop:
+	default:
+	}
+	return
+}
+
+// pop removes the oldest Message, ok is false if queue is empty.
+func (q *coalesceQueue) pop() (message Message, ok bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if len(q.keys) == 0 {
+		return
+	}
+	var key = q.keys[0]
+	q.keys = q.keys[1:]
+	message, ok = q.pending[key]
+	delete(q.pending, key)
+	return
+}
@@ -1,31 +1,67 @@
 package dispatch
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type Receiver interface {
 	// Get gets Message from Source with EventKey to filter.
 	Get() <-chan Message
+	// Update replaces the EventKey s this Receiver wants, a key can be a pattern
+	// like "domain.system.*" or "domain_#", see EventKey for the syntax.
 	Update(eventKeys []EventKey)
+	// Close unregisters Receiver from its Dispatcher and closes the channel from Get,
+	// so range loops over Get end.
+	Close()
+	// Overflows returns the number of Message s dropped because the reader was too slow.
+	Overflows() uint64
 }
 
 type innerReceiver interface {
 	// Put puts Message into Receiver.
-	// Receiver should do Filter.
+	// Dispatcher has done Filter by EventKey, Receiver does ReceiverConfig.Filter.
 	// message use nil can reduce unnecessary copy.
 	Put(message *Message)
+	// Bind binds Receiver to the index of a Dispatcher, so Update keeps index in sync.
+	Bind(idx *index)
 }
 
 type receiver struct {
-	messageChan  chan Message
-	eventKeysMap map[EventKey]void
-	mut          sync.RWMutex
+	config      ReceiverConfig
+	messageChan chan Message
+	coalesce    *coalesceQueue
+	overflows   atomic.Uint64
+	eventKeys   []EventKey
+	idx         *index
+	mut         sync.Mutex
+
+	done      chan void
+	closeOnce sync.Once
+	// putMut makes Close wait for in-flight Put before closing messageChan.
+	putMut sync.RWMutex
+	// pumped is closed when the goroutine moving coalesceQueue into messageChan exits.
+	pumped chan void
 }
 
 func NewReceiver() Receiver {
-	return &receiver{
-		eventKeysMap: make(map[EventKey]void),
-		messageChan:  make(chan Message),
+	return NewReceiverWithConfig(ReceiverConfig{})
+}
+
+func NewReceiverWithConfig(config ReceiverConfig) Receiver {
+	var r = &receiver{
+		config: config,
+		done:   make(chan void),
+	}
+	if config.Overflow == OverflowCoalesce {
+		r.messageChan = make(chan Message)
+		r.coalesce = newCoalesceQueue(config.BufferSize)
+		r.pumped = make(chan void)
+		go r.pump()
+	} else {
+		r.messageChan = make(chan Message, config.BufferSize)
 	}
+	return r
 }
 
 func (r *receiver) Get() <-chan Message {
@@ -33,24 +69,147 @@ func (r *receiver) Get() <-chan Message {
 }
 
 func (r *receiver) Update(eventKeys []EventKey) {
-	var newMap = make(map[EventKey]void)
+	var seen = make(map[EventKey]void)
+	var newKeys = make([]EventKey, 0, len(eventKeys))
 	for _, key := range eventKeys {
-		newMap[key] = noop
+		if _, has := seen[key]; has {
+			continue
+		}
+		seen[key] = noop
+		newKeys = append(newKeys, key)
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.idx != nil {
+		r.idx.replace(r, r.eventKeys, newKeys)
 	}
+	r.eventKeys = newKeys
+}
+
+func (r *receiver) Bind(idx *index) {
 	r.mut.Lock()
-	r.eventKeysMap = newMap
-	r.mut.Unlock()
+	defer r.mut.Unlock()
+	if r.idx != nil {
+		r.idx.replace(r, r.eventKeys, nil)
+	}
+	r.idx = idx
+	select {
+	case <-r.done:
+		// A closed Receiver can't be registered again.
+		r.idx = nil
+	default:
+	}
+	if r.idx != nil {
+		r.idx.replace(r, nil, r.eventKeys)
+	}
 }
 
 func (r *receiver) Put(message *Message) {
-	// fast skip.
-	r.mut.RLocker()
-	if _, has := r.eventKeysMap[message.Key]; !has {
+	r.putMut.RLock()
+	defer r.putMut.RUnlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	if r.config.Filter != nil && !r.config.Filter.Match(message) {
 		return
 	}
-	r.mut.RUnlock()
 
-	r.messageChan <- *message
+	switch r.config.Overflow {
+	case OverflowDropNewest:
+		select {
+		case r.messageChan <- *message:
+		default:
+			r.overflow(*message)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case r.messageChan <- *message:
+				return
+			default:
+			}
+			select {
+			case dropped := <-r.messageChan:
+				r.overflow(dropped)
+			default:
+				if cap(r.messageChan) == 0 {
+					// Nothing buffered to drop when unbuffered.
+					r.overflow(*message)
+					return
+				}
+			}
+		}
+	case OverflowCoalesce:
+		if dropped, overflow := r.coalesce.push(*message); overflow {
+			r.overflow(dropped)
+		}
+	case OverflowDisconnect:
+		select {
+		case r.messageChan <- *message:
+		default:
+			r.overflow(*message)
+			// Close waits for Put, don't wait for it here.
+			go r.Close()
+		}
+	default:
+		select {
+		case r.messageChan <- *message:
+		case <-r.done:
+		}
+	}
+}
+
+func (r *receiver) overflow(dropped Message) {
+	r.overflows.Add(1)
+	if r.config.OnOverflow != nil {
+		r.config.OnOverflow(r, dropped)
+	}
+}
+
+// pump moves Message s from coalesceQueue into messageChan.
+func (r *receiver) pump() {
+	defer close(r.pumped)
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.coalesce.signal:
+		}
+		for {
+			message, ok := r.coalesce.pop()
+			if !ok {
+				break
+			}
+			select {
+			case r.messageChan <- message:
+			case <-r.done:
+				return
+			}
+		}
+	}
+}
+
+func (r *receiver) Overflows() uint64 {
+	return r.overflows.Load()
+}
+
+func (r *receiver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.Bind(nil)
+
+		r.putMut.Lock()
+		if r.pumped != nil {
+			<-r.pumped
+		}
+		close(r.messageChan)
+		r.putMut.Unlock()
+	})
 }
 
 var _ Receiver = &receiver{}
@@ -0,0 +1,113 @@
+package dispatch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func drain(r Receiver) []EventKey {
+	var keys []EventKey
+	for {
+		select {
+		case msg, ok := <-r.Get():
+			if !ok {
+				return keys
+			}
+			keys = append(keys, msg.Key)
+		case <-time.After(time.Millisecond * 50):
+			return keys
+		}
+	}
+}
+
+func TestReceiverOverflow(t *testing.T) {
+	var cases = []struct {
+		policy    OverflowPolicy
+		keys      []EventKey
+		want      string
+		overflows uint64
+	}{
+		{OverflowDropNewest, []EventKey{"a", "b", "a", "c"}, "[a b]", 2},
+		{OverflowDropOldest, []EventKey{"a", "b", "a", "c"}, "[a c]", 2},
+		{OverflowDisconnect, []EventKey{"a", "b", "c"}, "[a b]", 1},
+	}
+
+	for _, c := range cases {
+		var dropped []EventKey
+		var r = NewReceiverWithConfig(ReceiverConfig{
+			BufferSize: 2,
+			Overflow:   c.policy,
+			OnOverflow: func(_ Receiver, message Message) {
+				dropped = append(dropped, message.Key)
+			},
+		})
+		for _, key := range c.keys {
+			r.(innerReceiver).Put(&Message{Key: key})
+		}
+		if c.policy == OverflowDisconnect {
+			time.Sleep(time.Millisecond * 50)
+		}
+
+		if got := fmt.Sprint(drain(r)); got != c.want {
+			t.Errorf("policy %d: got %v, want %v", c.policy, got, c.want)
+		}
+		if got := r.Overflows(); got != c.overflows || uint64(len(dropped)) != c.overflows {
+			t.Errorf("policy %d: got %d overflows and %d callbacks, want %d", c.policy, got, len(dropped), c.overflows)
+		}
+		r.Close()
+	}
+}
+
+func TestCoalesceQueue(t *testing.T) {
+	var q = newCoalesceQueue(2)
+	var dropped []string
+	for _, message := range []Message{
+		{Key: "a", Data: []byte("1")},
+		{Key: "b", Data: []byte("1")},
+		{Key: "a", Data: []byte("2")},
+		{Key: "c", Data: []byte("1")},
+	} {
+		if old, overflow := q.push(message); overflow {
+			dropped = append(dropped, old.Key+string(old.Data))
+		}
+	}
+
+	var got []string
+	for {
+		message, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, message.Key+string(message.Data))
+	}
+	if fmt.Sprint(got) != "[b1 c1]" || fmt.Sprint(dropped) != "[a1 a2]" {
+		t.Fatalf("got %v dropped %v, want [b1 c1] dropped [a1 a2]", got, dropped)
+	}
+}
+
+func TestReceiverFilter(t *testing.T) {
+	var r = NewReceiverWithConfig(ReceiverConfig{
+		BufferSize: 8,
+		Filter: And(
+			FieldEquals("tenant", "mine"),
+			Or(FieldIn("meta.level", 1, 2), Not(FieldEquals("meta.internal", true))),
+		),
+	})
+	defer r.Close()
+
+	for i, data := range []string{
+		`{"tenant":"mine","meta":{"level":1}}`,
+		`{"tenant":"other","meta":{"level":1}}`,
+		`{"tenant":"mine","meta":{"level":3,"internal":true}}`,
+		`{"tenant":"mine"}`,
+		`not json`,
+	} {
+		var message = Message{Key: fmt.Sprint(i), Data: []byte(data), payload: &payload{}}
+		r.(innerReceiver).Put(&message)
+	}
+
+	if got := fmt.Sprint(drain(r)); got != "[0 3]" {
+		t.Fatalf("got %v, want [0 3]", got)
+	}
+}
@@ -12,6 +12,11 @@ type innerSource interface {
 	Get() <-chan Message
 }
 
+// closableSource is a Source which Dispatcher closes when it stops reading it, like a bridge.
+type closableSource interface {
+	Close()
+}
+
 type source struct {
 	ctx         context.Context
 	cancel      context.CancelFunc
@@ -0,0 +1,192 @@
+package dispatch
+
+import (
+	"path"
+	"strings"
+)
+
+// EventKey s are hierarchical, segments are separated by '.' or '_', so
+// "domain.system.run" and "domain_system_run" are the same key.
+//
+// A pattern is an EventKey whose segments may be wildcards:
+// "*" matches exactly one segment, "domain.*.run" matches "domain_system_run";
+// "#" matches zero or more segments, "domain.#" matches "domain" and "domain_system_run";
+// any other segment containing '*', '?' or '[' is a glob on that segment, see path.Match.
+const (
+	wildcardOne  = "*"
+	wildcardMany = "#"
+)
+
+func isSeparator(r rune) bool {
+	return r == '.' || r == '_'
+}
+
+func splitKey(key EventKey) []string {
+	return strings.FieldsFunc(key, isSeparator)
+}
+
+func isGlob(segment string) bool {
+	return strings.ContainsAny(segment, "*?[")
+}
+
+// trie indexes patterns, and finds all values whose pattern matches an EventKey
+// without scanning every pattern.
+// trie is not safe for concurrent use, owner should hold a lock.
+type trie[V comparable] struct {
+	root *trieNode[V]
+}
+
+type trieNode[V comparable] struct {
+	children map[string]*trieNode[V]
+	globs    map[string]*trieNode[V]
+	one      *trieNode[V]
+	many     *trieNode[V]
+	values   map[V]void
+}
+
+func newTrie[V comparable]() *trie[V] {
+	return &trie[V]{root: &trieNode[V]{}}
+}
+
+// insert adds value under pattern.
+func (t *trie[V]) insert(pattern EventKey, value V) {
+	var n = t.root
+	for _, seg := range splitKey(pattern) {
+		n = n.child(seg, true)
+	}
+	if n.values == nil {
+		n.values = make(map[V]void)
+	}
+	n.values[value] = noop
+}
+
+// remove deletes value under pattern and prunes empty nodes.
+func (t *trie[V]) remove(pattern EventKey, value V) {
+	t.root.remove(splitKey(pattern), value)
+}
+
+// match calls fn for every value whose pattern matches key, fn returns false to stop.
+// A value may be visited more than once if several of its patterns match.
+func (t *trie[V]) match(key EventKey, fn func(V) bool) {
+	t.root.match(splitKey(key), fn)
+}
+
+// has reports whether any pattern matches key.
+func (t *trie[V]) has(key EventKey) bool {
+	var found bool
+	t.match(key, func(V) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+func (n *trieNode[V]) empty() bool {
+	return len(n.values) == 0 && len(n.children) == 0 && len(n.globs) == 0 && n.one == nil && n.many == nil
+}
+
+func (n *trieNode[V]) child(seg string, create bool) *trieNode[V] {
+	switch {
+	case seg == wildcardOne:
+		if n.one == nil && create {
+			n.one = &trieNode[V]{}
+		}
+		return n.one
+	case seg == wildcardMany:
+		if n.many == nil && create {
+			n.many = &trieNode[V]{}
+		}
+		return n.many
+	case isGlob(seg):
+		if n.globs == nil {
+			if !create {
+				return nil
+			}
+			n.globs = make(map[string]*trieNode[V])
+		}
+		return lookup(n.globs, seg, create)
+	default:
+		if n.children == nil {
+			if !create {
+				return nil
+			}
+			n.children = make(map[string]*trieNode[V])
+		}
+		return lookup(n.children, seg, create)
+	}
+}
+
+func lookup[V comparable](m map[string]*trieNode[V], seg string, create bool) *trieNode[V] {
+	c, has := m[seg]
+	if !has && create {
+		c = &trieNode[V]{}
+		m[seg] = c
+	}
+	return c
+}
+
+func (n *trieNode[V]) remove(segs []string, value V) {
+	if len(segs) == 0 {
+		delete(n.values, value)
+		return
+	}
+	var seg = segs[0]
+	var c = n.child(seg, false)
+	if c == nil {
+		return
+	}
+	c.remove(segs[1:], value)
+	if !c.empty() {
+		return
+	}
+	switch {
+	case seg == wildcardOne:
+		n.one = nil
+	case seg == wildcardMany:
+		n.many = nil
+	case isGlob(seg):
+		delete(n.globs, seg)
+	default:
+		delete(n.children, seg)
+	}
+}
+
+func (n *trieNode[V]) match(segs []string, fn func(V) bool) bool {
+	if n.many != nil {
+		// '#' swallows zero or more segments.
+		for i := 0; i <= len(segs); i++ {
+			if !n.many.match(segs[i:], fn) {
+				return false
+			}
+		}
+	}
+
+	if len(segs) == 0 {
+		for v := range n.values {
+			if !fn(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var seg, rest = segs[0], segs[1:]
+	if c, has := n.children[seg]; has {
+		if !c.match(rest, fn) {
+			return false
+		}
+	}
+	if n.one != nil {
+		if !n.one.match(rest, fn) {
+			return false
+		}
+	}
+	for glob, c := range n.globs {
+		if ok, _ := path.Match(glob, seg); ok {
+			if !c.match(rest, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
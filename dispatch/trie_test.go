@@ -0,0 +1,75 @@
+package dispatch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTrieMatch(t *testing.T) {
+	var cases = []struct {
+		pattern EventKey
+		key     EventKey
+		want    bool
+	}{
+		{"domain_system_module_componentA_run_fail", "domain_system_module_componentA_run_fail", true},
+		{"domain.system.module.componentA.run.fail", "domain_system_module_componentA_run_fail", true},
+		{"domain_system_module", "domain_system_module_componentA_run_fail", false},
+		{"domain.system.*", "domain_system_module", true},
+		{"domain.system.*", "domain_system_module_componentA", false},
+		{"domain.*.module.#", "domain_system_module_componentA_run_fail", true},
+		{"domain.#", "domain", true},
+		{"domain.#", "domain_system_module_componentA_run_fail", true},
+		{"domain.#.fail", "domain_system_module_componentA_run_fail", true},
+		{"domain.#.ok", "domain_system_module_componentA_run_fail", false},
+		{"#", "anything_at_all", true},
+		{"domain_system_module_component*_run_#", "domain_system_module_componentA_run_fail", true},
+		{"domain_system_module_component?_run_#", "domain_system_module_componentAB_run_fail", false},
+	}
+
+	for _, c := range cases {
+		var tr = newTrie[EventKey]()
+		tr.insert(c.pattern, c.pattern)
+		if got := tr.has(c.key); got != c.want {
+			t.Errorf("pattern %q key %q: got %v, want %v", c.pattern, c.key, got, c.want)
+		}
+	}
+}
+
+func TestTrieRemove(t *testing.T) {
+	var tr = newTrie[int]()
+	tr.insert("domain.#", 1)
+	tr.insert("domain.system.*", 2)
+	tr.insert("domain.system.*", 3)
+
+	tr.remove("domain.system.*", 2)
+	tr.remove("domain.#", 1)
+
+	var got []int
+	tr.match("domain_system_module", func(v int) bool {
+		got = append(got, v)
+		return true
+	})
+	if len(got) != 1 || got[0] != 3 {
+		t.Fatalf("got %v, want [3]", got)
+	}
+
+	tr.remove("domain.system.*", 3)
+	if !tr.root.empty() {
+		t.Fatal("trie should be pruned to an empty root")
+	}
+}
+
+func BenchmarkTrieMatch(b *testing.B) {
+	var tr = newTrie[int]()
+	for i := 0; i < 10000; i++ {
+		tr.insert(fmt.Sprintf("domain_system%d_module_#", i%100), i)
+		tr.insert(fmt.Sprintf("domain_system%d_module%d_run_fail", i%100, i), i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.match(fmt.Sprintf("domain_system%d_module%d_run_fail", i%100, i%10000), func(int) bool {
+			return true
+		})
+	}
+}
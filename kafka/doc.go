@@ -0,0 +1,2 @@
+// Package kafka holds options of Kafka clients shared by publish and subscribe.
+package kafka
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/IBM/sarama"
+	"time"
+)
+
+// Options configure a Kafka client, zero values keep defaults of sarama.
+type Options struct {
+	// Config is used instead of the other options when set, publish and subscribe still set
+	// fields they rely on, like Producer.Return.Successes.
+	Config *sarama.Config
+
+	ClientID string
+	// Version of brokers like "3.5.0", defaults to sarama.DefaultVersion.
+	Version string
+
+	// TLS enables TLS with it, like RootCAs of a custom CA or Certificates of client.
+	TLS  *tls.Config
+	SASL SASL
+
+	Producer ProducerOptions
+}
+
+// Mechanism is a SASL mechanism.
+type Mechanism string
+
+const (
+	SASLPlain       Mechanism = sarama.SASLTypePlaintext
+	SASLSCRAMSHA256 Mechanism = sarama.SASLTypeSCRAMSHA256
+	SASLSCRAMSHA512 Mechanism = sarama.SASLTypeSCRAMSHA512
+)
+
+// SASL authenticates the client, an empty Mechanism disables it.
+type SASL struct {
+	Mechanism Mechanism
+	User      string
+	Password  string
+}
+
+// Compression is a codec of producer batches.
+type Compression string
+
+const (
+	CompressionNone   Compression = ""
+	CompressionGzip   Compression = "gzip"
+	CompressionSnappy Compression = "snappy"
+	CompressionLZ4    Compression = "lz4"
+	CompressionZstd   Compression = "zstd"
+)
+
+type ProducerOptions struct {
+	Compression Compression
+	// Idempotent avoids duplicates of retries, it requires Version 0.11 or later, and sets
+	// acks of all replicas and one in-flight request per broker.
+	Idempotent bool
+
+	// Linger is how long to wait for a batch to fill.
+	Linger time.Duration
+	// BatchBytes and BatchMessages send a batch once it reaches either.
+	BatchBytes    int
+	BatchMessages int
+
+	// Retries of a failed send, defaults to 3, RetryBackoff defaults to 100ms.
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// NewConfig creates a sarama.Config of o, or a copy of o.Config.
+func (o Options) NewConfig() (*sarama.Config, error) {
+	if o.Config != nil {
+		var config = *o.Config
+		return &config, nil
+	}
+
+	var config = sarama.NewConfig()
+	if o.ClientID != "" {
+		config.ClientID = o.ClientID
+	}
+	if o.Version != "" {
+		version, err := sarama.ParseKafkaVersion(o.Version)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
+
+	if o.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = o.TLS
+	}
+	if o.SASL.Mechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(o.SASL.Mechanism)
+		config.Net.SASL.User = o.SASL.User
+		config.Net.SASL.Password = o.SASL.Password
+		switch o.SASL.Mechanism {
+		case SASLPlain:
+		case SASLSCRAMSHA256:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha256Hash) }
+		case SASLSCRAMSHA512:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha512Hash) }
+		default:
+			return nil, fmt.Errorf("kafka: unknown SASL mechanism %q", o.SASL.Mechanism)
+		}
+	}
+
+	if err := o.Producer.apply(config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (p ProducerOptions) apply(config *sarama.Config) error {
+	switch p.Compression {
+	case CompressionNone:
+	case CompressionGzip:
+		config.Producer.Compression = sarama.CompressionGZIP
+	case CompressionSnappy:
+		config.Producer.Compression = sarama.CompressionSnappy
+	case CompressionLZ4:
+		config.Producer.Compression = sarama.CompressionLZ4
+	case CompressionZstd:
+		config.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return fmt.Errorf("kafka: unknown compression %q", p.Compression)
+	}
+
+	config.Producer.Flush.Frequency = p.Linger
+	config.Producer.Flush.Bytes = p.BatchBytes
+	config.Producer.Flush.Messages = p.BatchMessages
+	if p.Retries > 0 {
+		config.Producer.Retry.Max = p.Retries
+	}
+	if p.RetryBackoff > 0 {
+		config.Producer.Retry.Backoff = p.RetryBackoff
+	}
+
+	if p.Idempotent {
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return fmt.Errorf("kafka: idempotent producer requires version 0.11 or later, got %s", config.Version)
+		}
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+		config.Producer.Retry.Max = max(config.Producer.Retry.Max, 1)
+	}
+	return nil
+}
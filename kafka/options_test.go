@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"testing"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	// Example of RFC 7677 section 3.
+	var c = newSCRAMClient(sha256Hash)
+	c.nonce = func() string { return "rOprNGfwEbeRWgbNEkqO" }
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps = []struct {
+		challenge string
+		want      string
+	}{
+		{"", "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"},
+		{"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", ""},
+	}
+	for i, step := range steps {
+		got, err := c.Step(step.challenge)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got != step.want {
+			t.Fatalf("step %d: got %q, want %q", i, got, step.want)
+		}
+	}
+	if !c.Done() {
+		t.Fatal("want done")
+	}
+
+	_ = c.Begin("user", "wrong", "")
+	_, _ = c.Step("")
+	_, _ = c.Step(steps[1].challenge)
+	if _, err := c.Step(steps[2].challenge); err == nil {
+		t.Fatal("want a signature mismatch of a wrong password")
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	config, err := Options{
+		ClientID: "wsevent",
+		Version:  "3.5.0",
+		SASL:     SASL{Mechanism: SASLSCRAMSHA512, User: "user", Password: "pencil"},
+		Producer: ProducerOptions{Compression: CompressionZstd, Idempotent: true, BatchMessages: 100},
+	}.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Net.SASL.Enable || config.Net.SASL.SCRAMClientGeneratorFunc == nil || config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 {
+		t.Errorf("unexpected SASL %+v", config.Net.SASL)
+	}
+	if !config.Producer.Idempotent || config.Net.MaxOpenRequests != 1 || config.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Error("idempotent producer is not set up")
+	}
+	if config.Producer.Compression != sarama.CompressionZSTD || config.Producer.Flush.Messages != 100 {
+		t.Error("producer options are not applied")
+	}
+
+	var cases = []Options{
+		{Version: "x"},
+		{SASL: SASL{Mechanism: "GSSAPI"}},
+		{Producer: ProducerOptions{Compression: "brotli"}},
+		{Version: "0.10.2.0", Producer: ProducerOptions{Idempotent: true}},
+	}
+	for i, c := range cases {
+		if _, err := c.NewConfig(); err == nil {
+			t.Errorf("case %d: want an error", i)
+		}
+	}
+}
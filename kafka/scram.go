@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+var (
+	sha256Hash = sha256.New
+	sha512Hash = sha512.New
+)
+
+// scramClient is a sarama.SCRAMClient of RFC 5802, passwords are used as is without SASLprep.
+type scramClient struct {
+	hash  func() hash.Hash
+	nonce func() string
+
+	user, password, authzID string
+	// clientNonce, clientFirstBare and serverSignature are kept between steps.
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+	done            bool
+}
+
+func newSCRAMClient(hash func() hash.Hash) *scramClient {
+	return &scramClient{hash: hash, nonce: randomNonce}
+}
+
+func randomNonce() string {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("kafka: read random, %v", err))
+	}
+	return base64.RawStdEncoding.EncodeToString(b[:])
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	c.user, c.password, c.authzID = user, password, authzID
+	c.step, c.done = 0, false
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		c.clientNonce = c.nonce()
+		c.clientFirstBare = "n=" + saslName(c.user) + ",r=" + c.clientNonce
+		return c.gs2Header() + c.clientFirstBare, nil
+	case 2:
+		return c.clientFinal(challenge)
+	case 3:
+		return "", c.verify(challenge)
+	}
+	return "", errors.New("kafka: scram exchange is over")
+}
+
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+func (c *scramClient) gs2Header() string {
+	if c.authzID == "" {
+		return "n,,"
+	}
+	return "n,a=" + saslName(c.authzID) + ","
+}
+
+// clientFinal answers server-first-message "r=<nonce>,s=<salt>,i=<iterations>" with a proof.
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	var attrs = scramAttributes(serverFirst)
+	if !strings.HasPrefix(attrs["r"], c.clientNonce) {
+		return "", errors.New("kafka: scram server nonce mismatch")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", fmt.Errorf("kafka: scram salt, %w", err)
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("kafka: scram iterations %q", attrs["i"])
+	}
+
+	var saltedPassword = pbkdf2.Key([]byte(c.password), salt, iterations, c.hash().Size(), c.hash)
+	var clientKey = c.hmac(saltedPassword, "Client Key")
+	var h = c.hash()
+	h.Write(clientKey)
+	var storedKey = h.Sum(nil)
+
+	var clientFinalBare = "c=" + base64.StdEncoding.EncodeToString([]byte(c.gs2Header())) + ",r=" + attrs["r"]
+	var authMessage = c.clientFirstBare + "," + serverFirst + "," + clientFinalBare
+	var proof = c.hmac(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = c.hmac(c.hmac(saltedPassword, "Server Key"), authMessage)
+	return clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks server-final-message "v=<signature>".
+func (c *scramClient) verify(serverFinal string) error {
+	var attrs = scramAttributes(serverFinal)
+	if e, has := attrs["e"]; has {
+		return fmt.Errorf("kafka: scram server error, %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, c.serverSignature) {
+		return errors.New("kafka: scram server signature mismatch")
+	}
+	c.done = true
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	var mac = hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func scramAttributes(message string) map[string]string {
+	var attrs = make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if key, value, found := strings.Cut(attr, "="); found {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// saslName escapes "=" and "," of a user name.
+func saslName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
@@ -1,6 +1,7 @@
 package publish
 
 import (
+	"context"
 	"github.com/istomyang/wsevent/log"
 )
 
@@ -15,6 +16,22 @@ func (f *fakePublisher) Send(data []byte) error {
 	return nil
 }
 
+func (f *fakePublisher) SendRecord(record Record) error {
+	log.Debug("fakePublisher-send-record: %s %s %v", string(record.Key), string(record.Data), record.Headers)
+	return nil
+}
+
+func (f *fakePublisher) SendSync(ctx context.Context, record Record) error {
+	return f.SendRecord(record)
+}
+
+func (f *fakePublisher) SendAsync(record Record, callback func(err error)) {
+	var err = f.SendRecord(record)
+	if callback != nil {
+		callback(err)
+	}
+}
+
 func (f *fakePublisher) Run() error {
 	log.Debug("fakePublisher: run")
 	return nil
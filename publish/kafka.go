@@ -2,8 +2,13 @@ package publish
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/IBM/sarama"
+	"github.com/istomyang/wsevent/kafka"
 	"github.com/istomyang/wsevent/log"
+	"github.com/istomyang/wsevent/merge"
+	"sync"
 )
 
 type kafkaPublisher struct {
@@ -11,52 +16,213 @@ type kafkaPublisher struct {
 	cancel   context.CancelFunc
 	config   KafkaConfig
 	producer sarama.AsyncProducer
+
+	// closed refuses sends, inputMut keeps them from racing with closing Input.
+	closed   bool
+	inputMut sync.RWMutex
+	// drained is closed when Successes and Errors are closed, closeErrs are errors drained
+	// after Close begins.
+	drained   chan struct{}
+	closeErrs []error
+	closeOnce sync.Once
+	closeErr  error
+
+	// calls are callbacks of SendAsync queued by drain, call runs them in order.
+	calls    []func()
+	callMut  sync.Mutex
+	callWake chan struct{}
+}
+
+// delivery is ProducerMessage.Metadata of a message, it reports the result of the message.
+type delivery struct {
+	callback func(err error)
+	// inline callbacks don't block, drain calls them itself.
+	inline bool
 }
 
 type KafkaConfig struct {
 	Hosts []string
 	Topic string
+
+	// Options configure the client, like TLS, SASL and compression, see kafka.Options.
+	Options kafka.Options
+
+	// Key derives the key of a record without Record.Key from its data, see EventName.
+	Key func(data []byte) merge.Key
+	// Partitioner defaults to sarama.NewHashPartitioner, records of a key go to one partition,
+	// the ones without a key go randomly.
+	Partitioner sarama.PartitionerConstructor
+}
+
+// EventName reads a string field of JSON data as the key, like the event name used by merge.Key.
+func EventName(field string) func(data []byte) merge.Key {
+	return func(data []byte) merge.Key {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return ""
+		}
+		var name string
+		if err := json.Unmarshal(fields[field], &name); err != nil {
+			return ""
+		}
+		return merge.Key(name)
+	}
 }
 
 func NewKafkaPublisher(ctx context.Context, config KafkaConfig) Publish {
 	ctx, cancel := context.WithCancel(ctx)
 	return &kafkaPublisher{
-		ctx:    ctx,
-		cancel: cancel,
-		config: config,
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		drained:  make(chan struct{}),
+		callWake: make(chan struct{}, 1),
 	}
 }
 
 func (k *kafkaPublisher) Send(data []byte) error {
+	return k.SendRecord(Record{Data: data})
+}
+
+func (k *kafkaPublisher) SendRecord(record Record) error {
+	return k.input(context.Background(), record, nil, false)
+}
+
+func (k *kafkaPublisher) SendSync(ctx context.Context, record Record) error {
+	var acked = make(chan error, 1)
+	if err := k.input(ctx, record, func(err error) {
+		acked <- err
+	}, true); err != nil {
+		return err
+	}
+	select {
+	case err := <-acked:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendAsync calls callbacks in order in a goroutine of their own, so a callback may block
+// or send again without stalling results of other sends.
+func (k *kafkaPublisher) SendAsync(record Record, callback func(err error)) {
+	if err := k.input(context.Background(), record, callback, false); err != nil && callback != nil {
+		callback(err)
+	}
+}
+
+// input puts record into producer until ctx ends, callback gets the result from drain, or
+// errors are logged without it, see delivery.
+func (k *kafkaPublisher) input(ctx context.Context, record Record, callback func(err error), inline bool) error {
 	var message = &sarama.ProducerMessage{
-		Topic: k.config.Topic,
-		Value: sarama.ByteEncoder(data),
+		Topic:     k.config.Topic,
+		Value:     sarama.ByteEncoder(record.Data),
+		Timestamp: record.Timestamp,
+		Metadata:  &delivery{callback: callback, inline: inline},
 	}
-lo:
-	for {
-		select {
-		case k.producer.Input() <- message:
-			log.Debug("kafkaPublisher-send: %v", string(data))
-			break lo
-		case err := <-k.producer.Errors():
-			log.Debug("kafkaPublisher-send: error %s", err.Error())
-			return err
+	var key = record.Key
+	if key == nil && k.config.Key != nil {
+		if name := k.config.Key(record.Data); name != "" {
+			key = []byte(name)
 		}
 	}
+	if key != nil {
+		message.Key = sarama.ByteEncoder(key)
+	}
+	for key, value := range record.Headers {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+
+	k.inputMut.RLock()
+	defer k.inputMut.RUnlock()
+	if k.closed || k.producer == nil {
+		return ErrPublisherClosed
+	}
+	select {
+	case k.producer.Input() <- message:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-k.ctx.Done():
+		return ErrPublisherClosed
+	}
+	log.Debug("kafkaPublisher-send: %v", string(record.Data))
 	return nil
 }
 
+// drain reports results of messages until producer is closed.
+func (k *kafkaPublisher) drain() {
+	defer close(k.drained)
+	var successes, errs = k.producer.Successes(), k.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case message, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			k.report(message, nil)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			k.report(err.Msg, err.Err)
+			if k.isClosed() {
+				k.closeErrs = append(k.closeErrs, err)
+			}
+		}
+	}
+}
+
+func (k *kafkaPublisher) isClosed() bool {
+	k.inputMut.RLock()
+	defer k.inputMut.RUnlock()
+	return k.closed
+}
+
+// report passes the result of message to its callback, callbacks of SendAsync are queued
+// for call, so a slow callback or one sending again doesn't stall drain.
+func (k *kafkaPublisher) report(message *sarama.ProducerMessage, err error) {
+	if d, ok := message.Metadata.(*delivery); ok && d.callback != nil {
+		if d.inline {
+			d.callback(err)
+			return
+		}
+		k.callMut.Lock()
+		k.calls = append(k.calls, func() { d.callback(err) })
+		k.callMut.Unlock()
+		select {
+		case k.callWake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	if err != nil {
+		log.Error("kafkaPublisher-send: error,", err)
+	}
+}
+
 func (k *kafkaPublisher) Run() error {
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Partitioner = sarama.NewRandomPartitioner
+	config, err := k.config.Options.NewConfig()
+	if err != nil {
+		return err
+	}
+	if k.config.Options.Config == nil {
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	}
+	if k.config.Partitioner != nil {
+		config.Producer.Partitioner = k.config.Partitioner
+	}
+	// drain reports results from both.
 	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
 
 	producer, err := sarama.NewAsyncProducer(k.config.Hosts, config)
 	if err != nil {
 		return err
 	}
-	k.producer = producer
+	k.start(producer)
 
 	go func() {
 		select {
@@ -73,13 +239,54 @@ func (k *kafkaPublisher) Run() error {
 	return nil
 }
 
+func (k *kafkaPublisher) start(producer sarama.AsyncProducer) {
+	k.producer = producer
+	go k.drain()
+	go k.call()
+}
+
+// call runs callbacks queued by report until drain ends and the queue is empty.
+func (k *kafkaPublisher) call() {
+	for {
+		var drained bool
+		select {
+		case <-k.callWake:
+		case <-k.drained:
+			drained = true
+		}
+		k.callMut.Lock()
+		var calls = k.calls
+		k.calls = nil
+		k.callMut.Unlock()
+		for _, call := range calls {
+			call()
+		}
+		if drained {
+			return
+		}
+	}
+}
+
+// Close flushes buffered messages and waits for their results, it returns errors of the ones
+// failed meanwhile joined.
 func (k *kafkaPublisher) Close() error {
-	var err error
-	defer k.cancel()
-	err = k.producer.Close()
+	// cancel releases sends waiting for Input, so they don't hold inputMut.
+	k.cancel()
+	k.closeOnce.Do(func() {
+		k.inputMut.Lock()
+		k.closed = true
+		var producer = k.producer
+		k.inputMut.Unlock()
+		if producer == nil {
+			return
+		}
 
-	log.Debug("kafkaPublisher: close")
-	return err
+		producer.AsyncClose()
+		<-k.drained
+		k.closeErr = errors.Join(k.closeErrs...)
+		log.Debug("kafkaPublisher: close")
+	})
+	return k.closeErr
 }
 
 var _ Publish = &kafkaPublisher{}
@@ -0,0 +1,191 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"testing"
+	"time"
+)
+
+func TestKafkaPublisherKey(t *testing.T) {
+	var config = sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	var producer = mocks.NewAsyncProducer(t, config)
+
+	var at = time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	var expectKey = func(want string) {
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+			var got []byte
+			if message.Key != nil {
+				got, _ = message.Key.Encode()
+			}
+			if string(got) != want {
+				t.Errorf("got key %q, want %q", got, want)
+			}
+			if !message.Timestamp.Equal(at) {
+				t.Errorf("got timestamp %v, want %v", message.Timestamp, at)
+			}
+			if len(message.Headers) != 1 || string(message.Headers[0].Key) != "tenant" || string(message.Headers[0].Value) != "mine" {
+				t.Errorf("got headers %v, want tenant: mine", message.Headers)
+			}
+			return nil
+		})
+	}
+	expectKey("order-1")
+	expectKey("domain_system_run")
+	expectKey("")
+
+	var pub = NewKafkaPublisher(context.Background(), KafkaConfig{Topic: "topic-test", Key: EventName("EventName")}).(*kafkaPublisher)
+	pub.start(producer)
+	defer pub.Close()
+
+	var records = []Record{
+		{Key: []byte("order-1"), Data: []byte(`{"EventName":"domain_system_run"}`)},
+		{Data: []byte(`{"EventName":"domain_system_run"}`)},
+		{Data: []byte(`not json`)},
+	}
+	for _, record := range records {
+		record.Headers = map[string]string{"tenant": "mine"}
+		record.Timestamp = at
+		if err := pub.SendRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKafkaPublisherAck(t *testing.T) {
+	var config = sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	var producer = mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrMessageTooLarge)
+
+	var pub = NewKafkaPublisher(context.Background(), KafkaConfig{Topic: "topic-test"}).(*kafkaPublisher)
+	pub.start(producer)
+
+	var ctx = context.Background()
+	if err := pub.SendSync(ctx, Record{Data: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.SendSync(ctx, Record{Data: []byte("b")}); !errors.Is(err, sarama.ErrOutOfBrokers) {
+		t.Fatalf("got %v, want ErrOutOfBrokers", err)
+	}
+
+	var results = make(chan error, 2)
+	for _, data := range []string{"c", "d"} {
+		pub.SendAsync(Record{Data: []byte(data)}, func(err error) {
+			results <- err
+		})
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; !errors.Is(err, sarama.ErrMessageTooLarge) {
+		t.Fatalf("got %v, want ErrMessageTooLarge", err)
+	}
+
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.SendSync(ctx, Record{Data: []byte("late")}); err != ErrPublisherClosed {
+		t.Fatalf("got %v, want ErrPublisherClosed", err)
+	}
+}
+
+// stuckProducer never takes messages from Input.
+type stuckProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newStuckProducer() *stuckProducer {
+	return &stuckProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (p *stuckProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *stuckProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *stuckProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *stuckProducer) AsyncClose() {
+	go func() {
+		p.errors <- &sarama.ProducerError{Msg: &sarama.ProducerMessage{}, Err: sarama.ErrOutOfBrokers}
+		close(p.successes)
+		close(p.errors)
+	}()
+}
+
+func TestKafkaPublisherStuck(t *testing.T) {
+	var pub = NewKafkaPublisher(context.Background(), KafkaConfig{Topic: "topic-test"}).(*kafkaPublisher)
+	pub.start(newStuckProducer())
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := pub.SendSync(ctx, Record{Data: []byte("a")}); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+
+	// A send waiting for Input doesn't hold Close, and Close reports failed deliveries.
+	var sent = make(chan error, 1)
+	go func() {
+		sent <- pub.SendRecord(Record{Data: []byte("b")})
+	}()
+	time.Sleep(time.Millisecond * 10)
+	if err := pub.Close(); !errors.Is(err, sarama.ErrOutOfBrokers) {
+		t.Fatalf("got %v, want ErrOutOfBrokers", err)
+	}
+	if err := <-sent; err != ErrPublisherClosed {
+		t.Fatalf("got %v, want ErrPublisherClosed", err)
+	}
+}
+
+func TestKafkaPublisherCallbackSend(t *testing.T) {
+	var config = sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	var producer = mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+
+	var pub = NewKafkaPublisher(context.Background(), KafkaConfig{Topic: "topic-test"}).(*kafkaPublisher)
+	pub.start(producer)
+
+	// A callback sending again waits for drain, which must not wait for the callback.
+	var resent = make(chan error, 1)
+	pub.SendAsync(Record{Data: []byte("a")}, func(err error) {
+		if err != nil {
+			resent <- err
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resent <- pub.SendSync(ctx, Record{Data: []byte("b")})
+	})
+	select {
+	case err := <-resent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("callback sending again is stuck")
+	}
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,487 @@
+package publish
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/istomyang/wsevent/log"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type OutboxConfig struct {
+	// Dir keeps the log and the cursor of the outbox, it is created if missing.
+	// Only one outbox may use a Dir at a time.
+	Dir string
+	// Target is where the relay forwards records, like a Kafka Publish, it must be running.
+	Target Publish
+
+	// NoSync skips fsync of every append, faster but a crash of OS may lose records.
+	NoSync bool
+	// SendTimeout of each forward, defaults to 10s.
+	SendTimeout time.Duration
+	// MinBackoff and MaxBackoff are between retries of a failed forward, they default to 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// CompactSize is the size of the log to truncate once all its records are forwarded, defaults to 16MiB.
+	CompactSize int64
+	// MaxAttempts of forwarding a record before it is dropped, like one Target rejects for good,
+	// defaults to 0 to retry until forwarded.
+	MaxAttempts int
+
+	// OnError will call when a forward fails, the record is retried until MaxAttempts.
+	OnError func(err error, record Record)
+	// OnDrop will call with a record dropped after MaxAttempts, like to keep it in a dead letter
+	// queue, SendSync and SendAsync waiting for it get err. Default logs the error.
+	OnDrop func(err error, record Record)
+}
+
+const (
+	outboxLog    = "outbox.log"
+	outboxCursor = "outbox.cursor"
+	// frameHeader is the length and the CRC32 of a frame payload.
+	frameHeader = 8
+)
+
+var errCorruptFrame = errors.New("publish: corrupt outbox frame")
+
+// outboxPublisher appends records to a log in Dir and returns, a relay forwards them to Target
+// in order and moves the cursor after each is acked, so records survive restarts and are sent
+// at least once. Callbacks of SendAsync and waits of SendSync don't survive restarts.
+type outboxPublisher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	config OutboxConfig
+
+	file *os.File
+	// size is the end of the log, cursor is the end of the last forwarded record.
+	size   int64
+	cursor int64
+	// waiters are keyed by end offsets of records.
+	waiters map[int64][]func(err error)
+	closed  bool
+	// running is set by Run, an outbox runs only once.
+	running bool
+	mut     sync.Mutex
+
+	notify  chan struct{}
+	relayed chan struct{}
+}
+
+func NewOutboxPublisher(ctx context.Context, config OutboxConfig) Publish {
+	if config.SendTimeout <= 0 {
+		config.SendTimeout = time.Second * 10
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Millisecond * 100
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Second * 30
+	}
+	if config.CompactSize <= 0 {
+		config.CompactSize = 16 << 20
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error, record Record) {
+			log.Error("outboxPublisher: forward, retry later,", err)
+		}
+	}
+	if config.OnDrop == nil {
+		config.OnDrop = func(err error, record Record) {
+			log.Error("outboxPublisher: drop a record after max attempts,", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &outboxPublisher{
+		ctx:     ctx,
+		cancel:  cancel,
+		config:  config,
+		waiters: make(map[int64][]func(err error)),
+		notify:  make(chan struct{}, 1),
+		relayed: make(chan struct{}),
+		closed:  true,
+	}
+}
+
+// Run opens the outbox, drops a torn record at its end by a crash, and starts the relay.
+// It returns an error if the outbox is already running or closed, create another one instead.
+func (o *outboxPublisher) Run() error {
+	o.mut.Lock()
+	if o.running || o.ctx.Err() != nil {
+		o.mut.Unlock()
+		return errors.New("publish: outbox is already running or closed")
+	}
+	o.running = true
+	o.mut.Unlock()
+
+	file, cursor, size, err := o.open()
+	if err != nil {
+		o.mut.Lock()
+		o.running = false
+		o.mut.Unlock()
+		return err
+	}
+
+	o.mut.Lock()
+	o.file, o.size, o.cursor, o.closed = file, size, cursor, false
+	o.mut.Unlock()
+
+	go o.relay()
+	go func() {
+		select {
+		case <-o.ctx.Done():
+			if err := o.Close(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
+	log.Debug("outboxPublisher: run, %d bytes to forward", size-cursor)
+	return nil
+}
+
+// open opens the log and recovers it, see recoverLog.
+func (o *outboxPublisher) open() (file *os.File, cursor int64, size int64, err error) {
+	if err := os.MkdirAll(o.config.Dir, 0o755); err != nil {
+		return nil, 0, 0, err
+	}
+	file, err = os.OpenFile(filepath.Join(o.config.Dir, outboxLog), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	cursor, err = o.readCursor()
+	if err == nil {
+		cursor, size, err = recoverLog(file, cursor)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, 0, err
+	}
+	return file, cursor, size, nil
+}
+
+// recoverLog returns cursor and the end of valid frames after it, a torn tail is truncated.
+// A cursor beyond the log is reset to forward all of it again.
+func recoverLog(file *os.File, cursor int64) (int64, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if cursor > info.Size() {
+		cursor = 0
+	}
+	var end = cursor
+	for end < info.Size() {
+		_, next, err := readFrame(file, end, info.Size())
+		if err != nil {
+			log.Error("outboxPublisher: truncate torn log at", end, err)
+			return cursor, end, file.Truncate(end)
+		}
+		end = next
+	}
+	return cursor, end, nil
+}
+
+func (o *outboxPublisher) Send(data []byte) error {
+	return o.SendRecord(Record{Data: data})
+}
+
+// SendRecord returns after record is in the outbox, not after it is forwarded.
+func (o *outboxPublisher) SendRecord(record Record) error {
+	return o.append(record, nil)
+}
+
+// SendSync waits until record is forwarded and acked by Target.
+func (o *outboxPublisher) SendSync(ctx context.Context, record Record) error {
+	var acked = make(chan error, 1)
+	if err := o.append(record, func(err error) {
+		acked <- err
+	}); err != nil {
+		return err
+	}
+	select {
+	case err := <-acked:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *outboxPublisher) SendAsync(record Record, callback func(err error)) {
+	if err := o.append(record, callback); err != nil && callback != nil {
+		callback(err)
+	}
+}
+
+func (o *outboxPublisher) append(record Record, callback func(err error)) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var frame = make([]byte, frameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[frameHeader:], payload)
+
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	if o.closed {
+		return ErrPublisherClosed
+	}
+	if err := o.write(frame); err != nil {
+		// Drop a partial frame so the next append starts clean.
+		_ = o.file.Truncate(o.size)
+		return err
+	}
+	o.size += int64(len(frame))
+	if callback != nil {
+		o.waiters[o.size] = append(o.waiters[o.size], callback)
+	}
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	log.Debug("outboxPublisher-send: %v", string(record.Data))
+	return nil
+}
+
+func (o *outboxPublisher) write(frame []byte) error {
+	if _, err := o.file.Write(frame); err != nil {
+		return err
+	}
+	if o.config.NoSync {
+		return nil
+	}
+	return o.file.Sync()
+}
+
+// relay forwards records after cursor until closed, failures of the log are retried with
+// backoff, so the relay never stops while records are still appended.
+func (o *outboxPublisher) relay() {
+	defer close(o.relayed)
+	for attempt := 0; ; {
+		o.mut.Lock()
+		var cursor, size = o.cursor, o.size
+		o.mut.Unlock()
+
+		if cursor == size {
+			o.compact()
+			select {
+			case <-o.notify:
+				continue
+			case <-o.ctx.Done():
+				return
+			}
+		}
+
+		record, next, err := readFrame(o.file, cursor, size)
+		if err != nil {
+			log.Error("outboxPublisher: read log, retry later,", err)
+			if !o.backoff(attempt) {
+				return
+			}
+			attempt++
+			continue
+		}
+		attempt = 0
+
+		running, dropped := o.forward(record)
+		if !running {
+			return
+		}
+		for attempt := 0; ; attempt++ {
+			var err = o.advance(next, dropped)
+			if err == nil {
+				break
+			}
+			log.Error("outboxPublisher: write cursor, retry later,", err)
+			if !o.backoff(attempt) {
+				return
+			}
+		}
+	}
+}
+
+// forward sends record to Target until it is acked or MaxAttempts is reached, then dropped is
+// the last error. running is false if closed.
+func (o *outboxPublisher) forward(record Record) (running bool, dropped error) {
+	for attempt := 0; ; attempt++ {
+		var ctx, cancel = context.WithTimeout(o.ctx, o.config.SendTimeout)
+		var err = o.config.Target.SendSync(ctx, record)
+		cancel()
+		if err == nil {
+			return true, nil
+		}
+		if o.ctx.Err() != nil {
+			return false, nil
+		}
+		if o.config.MaxAttempts > 0 && attempt+1 >= o.config.MaxAttempts {
+			o.config.OnDrop(err, record)
+			return true, err
+		}
+		o.config.OnError(err, record)
+		if !o.backoff(attempt) {
+			return false, nil
+		}
+	}
+}
+
+// backoff waits before the next attempt, it returns false if closed.
+func (o *outboxPublisher) backoff(attempt int) bool {
+	var backoff = o.config.MinBackoff
+	for i := 0; i < attempt && backoff < o.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	select {
+	case <-time.After(min(backoff, o.config.MaxBackoff)):
+		return true
+	case <-o.ctx.Done():
+		return false
+	}
+}
+
+// advance persists cursor and reports the record ends at it with err, nil if it is forwarded.
+func (o *outboxPublisher) advance(cursor int64, err error) error {
+	if err := o.writeCursor(cursor); err != nil {
+		return err
+	}
+	o.mut.Lock()
+	o.cursor = cursor
+	var waiters = o.waiters[cursor]
+	delete(o.waiters, cursor)
+	o.mut.Unlock()
+
+	for _, callback := range waiters {
+		callback(err)
+	}
+	return nil
+}
+
+// compact truncates the log once all records are forwarded and it grows over CompactSize.
+// Cursor is reset first, a crash between forwards the old records again, at least once.
+func (o *outboxPublisher) compact() {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	if o.cursor != o.size || o.size < o.config.CompactSize {
+		return
+	}
+	if err := o.writeCursor(0); err != nil {
+		log.Error("outboxPublisher: compact,", err)
+		return
+	}
+	if err := o.file.Truncate(0); err != nil {
+		log.Error("outboxPublisher: compact,", err)
+	}
+	o.size, o.cursor = 0, 0
+	log.Debug("outboxPublisher: compact")
+}
+
+func (o *outboxPublisher) readCursor() (int64, error) {
+	data, err := os.ReadFile(filepath.Join(o.config.Dir, outboxCursor))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("publish: corrupt outbox cursor of %d bytes", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// writeCursor replaces the cursor file by rename, so it is never torn.
+func (o *outboxPublisher) writeCursor(cursor int64) error {
+	var path = filepath.Join(o.config.Dir, outboxCursor)
+	var data = make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(cursor))
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if !o.config.NoSync {
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readFrame reads the record at offset before size, next is the offset after it.
+func readFrame(file *os.File, offset int64, size int64) (record Record, next int64, err error) {
+	var header = make([]byte, frameHeader)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		if err == io.EOF {
+			err = errCorruptFrame
+		}
+		return record, 0, err
+	}
+	var length = int64(binary.BigEndian.Uint32(header[0:4]))
+	if offset+frameHeader+length > size {
+		return record, 0, errCorruptFrame
+	}
+	var payload = make([]byte, length)
+	if _, err := file.ReadAt(payload, offset+frameHeader); err != nil {
+		if err == io.EOF {
+			err = errCorruptFrame
+		}
+		return record, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return record, 0, errCorruptFrame
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, errCorruptFrame
+	}
+	return record, offset + frameHeader + int64(len(payload)), nil
+}
+
+// Close stops the relay, records not forwarded yet stay in the outbox for the next Run,
+// SendSync and SendAsync waiting for them get ErrPublisherClosed.
+func (o *outboxPublisher) Close() error {
+	o.mut.Lock()
+	if o.closed {
+		o.mut.Unlock()
+		o.cancel()
+		return nil
+	}
+	o.closed = true
+	o.mut.Unlock()
+
+	o.cancel()
+	<-o.relayed
+
+	o.mut.Lock()
+	var waiters = o.waiters
+	o.waiters = make(map[int64][]func(err error))
+	o.mut.Unlock()
+	for _, callbacks := range waiters {
+		for _, callback := range callbacks {
+			callback(ErrPublisherClosed)
+		}
+	}
+
+	log.Debug("outboxPublisher: close")
+	return o.file.Close()
+}
+
+var _ Publish = &outboxPublisher{}
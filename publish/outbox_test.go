@@ -0,0 +1,220 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// targetPublisher records data sent by SendSync, it fails while down.
+type targetPublisher struct {
+	fakePublisher
+	sent []string
+	down bool
+	mut  sync.Mutex
+}
+
+func (p *targetPublisher) SendSync(ctx context.Context, record Record) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.down {
+		return errors.New("broker is down")
+	}
+	if string(record.Data) == "too large" {
+		return errors.New("message too large")
+	}
+	p.sent = append(p.sent, string(record.Data))
+	return nil
+}
+
+func (p *targetPublisher) setDown(down bool) {
+	p.mut.Lock()
+	p.down = down
+	p.mut.Unlock()
+}
+
+func (p *targetPublisher) wait(t *testing.T, n int) []string {
+	var deadline = time.Now().Add(time.Second * 5)
+	for {
+		p.mut.Lock()
+		var sent = append([]string(nil), p.sent...)
+		p.mut.Unlock()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want %d records", sent, n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestOutboxPublisher(t *testing.T) {
+	var dir = t.TempDir()
+	var target = &targetPublisher{down: true}
+	var config = OutboxConfig{
+		Dir:         dir,
+		Target:      target,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond * 10,
+		CompactSize: 1,
+		OnError:     func(err error, record Record) {},
+	}
+
+	// Records are kept while target is down, and forwarded after restart.
+	var pub = NewOutboxPublisher(context.Background(), config)
+	if err := pub.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := pub.Send([]byte(fmt.Sprint("a", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var failed = make(chan error, 1)
+	pub.SendAsync(Record{Data: []byte("a3")}, func(err error) {
+		failed <- err
+	})
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-failed; err != ErrPublisherClosed {
+		t.Fatalf("got %v, want ErrPublisherClosed", err)
+	}
+
+	// A torn record of a crash is dropped.
+	file, err := os.OpenFile(filepath.Join(dir, outboxLog), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write([]byte{0, 0, 1, 0, 0xff})
+	_ = file.Close()
+
+	target.setDown(false)
+	pub = NewOutboxPublisher(context.Background(), config)
+	if err := pub.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if got := fmt.Sprint(target.wait(t, 4)); got != "[a0 a1 a2 a3]" {
+		t.Fatalf("got %s, want [a0 a1 a2 a3]", got)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := pub.SendSync(ctx, Record{Data: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(target.wait(t, 5)); got != "[a0 a1 a2 a3 b]" {
+		t.Fatalf("got %s, want [a0 a1 a2 a3 b]", got)
+	}
+
+	// Forwarded records are compacted away and not sent again after restart.
+	var deadline = time.Now().Add(time.Second * 5)
+	for {
+		if info, err := os.Stat(filepath.Join(dir, outboxLog)); err == nil && info.Size() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log is not compacted")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_ = pub.Close()
+	pub = NewOutboxPublisher(context.Background(), config)
+	if err := pub.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	time.Sleep(time.Millisecond * 50)
+	if got := len(target.wait(t, 5)); got != 5 {
+		t.Fatalf("got %d records after restart, want 5", got)
+	}
+}
+
+func TestOutboxPublisherDrop(t *testing.T) {
+	var target = &targetPublisher{}
+	var dropped = make(chan Record, 1)
+	var pub = NewOutboxPublisher(context.Background(), OutboxConfig{
+		Dir:         t.TempDir(),
+		Target:      target,
+		MinBackoff:  time.Millisecond,
+		MaxAttempts: 3,
+		OnError:     func(err error, record Record) {},
+		OnDrop: func(err error, record Record) {
+			dropped <- record
+		},
+	})
+	if err := pub.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.Run(); err == nil {
+		t.Fatal("want an error of running twice")
+	}
+
+	// A record rejected for good is dropped and doesn't block later ones.
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := pub.SendSync(ctx, Record{Data: []byte("too large")}); err == nil || err.Error() != "message too large" {
+		t.Fatalf("got %v, want the error of target", err)
+	}
+	if record := <-dropped; string(record.Data) != "too large" {
+		t.Fatalf("dropped %s", record.Data)
+	}
+	if err := pub.SendSync(ctx, Record{Data: []byte("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(target.wait(t, 1)); got != "[c]" {
+		t.Fatalf("got %s, want [c]", got)
+	}
+}
+
+func TestOutboxPublisherCursorRetry(t *testing.T) {
+	var dir = filepath.Join(t.TempDir(), "outbox")
+	var target = &targetPublisher{down: true}
+	var pub = NewOutboxPublisher(context.Background(), OutboxConfig{
+		Dir:        dir,
+		Target:     target,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond * 10,
+		OnError:    func(err error, record Record) {},
+	})
+	if err := pub.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	// The cursor can't be written while Dir is gone, the relay retries instead of stopping.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	var acked = make(chan error, 1)
+	pub.SendAsync(Record{Data: []byte("a")}, func(err error) {
+		acked <- err
+	})
+	target.setDown(false)
+	target.wait(t, 1)
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case err := <-acked:
+		t.Fatalf("got %v before the cursor is written", err)
+	default:
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("relay stopped after a failed cursor write")
+	}
+}
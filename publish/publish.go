@@ -1,11 +1,39 @@
 package publish
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPublisherClosed is returned by sends after Publish is closed or before it runs.
+var ErrPublisherClosed = errors.New("publish: publisher is closed")
+
 type Publish interface {
 	// Send sends data to Broker.
 	// Note that data is recommended to design into a struct include a string key and a bytes type data.
 	//
 	// Suggestion: Use merge.Merge to merge same events in a tiny interval.
 	Send(data []byte) error
+	// SendRecord is like Send but carries metadata with data.
+	// Send and SendRecord don't wait for Broker, errors of delivery are logged.
+	SendRecord(record Record) error
+	// SendSync sends record and waits for Broker to ack it, or ctx ends.
+	SendSync(ctx context.Context, record Record) error
+	// SendAsync sends record and calls callback with the result of delivery, nil on success.
+	// callback may run in another goroutine.
+	SendAsync(record Record, callback func(err error))
 	Run() error
 	Close() error
 }
+
+// Record is data to send with its metadata.
+type Record struct {
+	// Key keeps records of an entity in order, they are sent to the same partition.
+	Key  []byte
+	Data []byte
+	// Headers are sent as Kafka record headers, like trace ID, content type or tenant.
+	Headers map[string]string
+	// Timestamp defaults to now.
+	Timestamp time.Time
+}
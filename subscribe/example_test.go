@@ -3,6 +3,8 @@ package subscribe
 import (
 	"context"
 	"encoding/json"
+	"github.com/istomyang/wsevent/log"
+	"time"
 )
 
 type Message struct {
@@ -12,8 +14,7 @@ type Message struct {
 
 func Example_ws() {
 	var sub = NewWsSubscriber(context.Background(), WsConfig{
-		Addr: "localhost:8080",
-		Path: "/ws-test",
+		URL: "ws://localhost:8080/ws-test",
 	})
 	_ = sub.Run()
 	defer sub.Close()
@@ -46,3 +47,29 @@ func Example_kafka() {
 
 	// Output:
 }
+
+func Example_kafkaGroup() {
+	var sub = NewKafkaSubscriber(context.Background(), KafkaConfig{
+		Hosts: []string{"localhost:3096"},
+		Topic: "topic-test",
+		Group: KafkaGroupConfig{
+			ID:        "group-test",
+			Rebalance: RebalanceSticky,
+			Initial:   OffsetAt(time.Now().Add(-time.Hour)),
+			OnRebalance: func(event RebalanceEvent) {
+				log.Info("rebalance:", event.Assigned, event.Claims)
+			},
+		},
+	})
+	_ = sub.Run()
+	defer sub.Close()
+
+	records, _ := sub.(RecordSubscribe).GetRecords()
+
+	for record := range records {
+		var m Message
+		_ = json.Unmarshal(record.Data, &m)
+		// Commit the offset after the record is handled.
+		record.Ack()
+	}
+}
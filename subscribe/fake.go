@@ -8,6 +8,8 @@ type fakeSubscriber struct {
 
 type FakeConfig struct {
 	PublishSend <-chan []byte
+	// OnAck will call when a Record from GetRecords is acked.
+	OnAck func(record Record)
 }
 
 func NewFakeSubscriber(config FakeConfig) Subscribe {
@@ -18,6 +20,25 @@ func (f *fakeSubscriber) Get() (<-chan []byte, error) {
 	return f.config.PublishSend, nil
 }
 
+func (f *fakeSubscriber) GetRecords() (<-chan Record, error) {
+	var records = Records(f.config.PublishSend, "fake")
+	if f.config.OnAck == nil {
+		return records, nil
+	}
+	var out = make(chan Record)
+	go func() {
+		defer close(out)
+		for record := range records {
+			var acked = record
+			record.ack = func() {
+				f.config.OnAck(acked)
+			}
+			out <- record
+		}
+	}()
+	return out, nil
+}
+
 func (f *fakeSubscriber) Run() error {
 	log.Debug("fakeSubscriber: run")
 	return nil
@@ -28,4 +49,4 @@ func (f *fakeSubscriber) Close() error {
 	return nil
 }
 
-var _ Subscribe = &fakeSubscriber{}
+var _ RecordSubscribe = &fakeSubscriber{}
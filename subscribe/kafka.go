@@ -3,8 +3,11 @@ package subscribe
 import (
 	"context"
 	"github.com/IBM/sarama"
+	"github.com/istomyang/wsevent/kafka"
 	"github.com/istomyang/wsevent/log"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type KafkaConfig struct {
@@ -13,6 +16,71 @@ type KafkaConfig struct {
 	// One consumer should bind to one partition.
 	PartitionID int32
 	Topic       string
+
+	// Group consumes all partitions of Topic in a consumer group when Group.ID is set,
+	// PartitionID is ignored then.
+	Group KafkaGroupConfig
+
+	// Options configure the client, like TLS and SASL, see kafka.Options.
+	Options kafka.Options
+}
+
+// KafkaGroupConfig configures a consumer group, an offset is committed after its Record is
+// dispatched, see Record.Ack, so a restarted or rebalanced consumer goes on from there.
+// Get has no Ack, it commits an offset once the data is taken from its channel, use
+// GetRecords to commit after dispatch.
+type KafkaGroupConfig struct {
+	ID string
+	// Rebalance defaults to RebalanceRange.
+	Rebalance Rebalance
+	// Initial is where to start on partitions without a committed offset, defaults to OffsetNewest.
+	Initial InitialOffset
+	// OnRebalance will call when partitions are assigned to or revoked from this consumer.
+	OnRebalance func(event RebalanceEvent)
+}
+
+// Rebalance is the strategy to assign partitions among consumers of a group.
+type Rebalance int
+
+const (
+	RebalanceRange Rebalance = iota
+	RebalanceRoundRobin
+	RebalanceSticky
+)
+
+func (r Rebalance) strategy() sarama.BalanceStrategy {
+	switch r {
+	case RebalanceRoundRobin:
+		return sarama.NewBalanceStrategyRoundRobin()
+	case RebalanceSticky:
+		return sarama.NewBalanceStrategySticky()
+	default:
+		return sarama.NewBalanceStrategyRange()
+	}
+}
+
+// InitialOffset is one of OffsetNewest, OffsetOldest and OffsetAt.
+type InitialOffset struct {
+	offset int64
+	at     time.Time
+}
+
+var (
+	OffsetNewest = InitialOffset{offset: sarama.OffsetNewest}
+	OffsetOldest = InitialOffset{offset: sarama.OffsetOldest}
+)
+
+// OffsetAt starts from the first message at or after t, or the newest if none.
+func OffsetAt(t time.Time) InitialOffset {
+	return InitialOffset{offset: sarama.OffsetNewest, at: t}
+}
+
+// RebalanceEvent reports partitions of topics assigned to or revoked from this consumer.
+type RebalanceEvent struct {
+	Assigned     bool
+	Claims       map[string][]int32
+	MemberID     string
+	GenerationID int32
 }
 
 type kafkaSubscriber struct {
@@ -20,8 +88,13 @@ type kafkaSubscriber struct {
 	cancel     context.CancelFunc
 	config     KafkaConfig
 	consumer   sarama.Consumer
+	client     sarama.Client
+	group      sarama.ConsumerGroup
 	messages   chan []byte
+	records    chan Record
 	chanClosed atomic.Bool
+	// consuming counts goroutines which put into messages or records.
+	consuming sync.WaitGroup
 }
 
 func NewKafkaSubscriber(ctx context.Context, config KafkaConfig) Subscribe {
@@ -31,37 +104,143 @@ func NewKafkaSubscriber(ctx context.Context, config KafkaConfig) Subscribe {
 		cancel:   cancel,
 		config:   config,
 		messages: make(chan []byte),
+		records:  make(chan Record),
 	}
 }
 
+// Get acks a message when it is taken, see KafkaGroupConfig.
 func (k *kafkaSubscriber) Get() (<-chan []byte, error) {
+	err := k.consume(k.putMessage)
+	return k.messages, err
+}
+
+func (k *kafkaSubscriber) GetRecords() (<-chan Record, error) {
+	err := k.consume(k.putRecord)
+	return k.records, err
+}
+
+func (k *kafkaSubscriber) putMessage(message *sarama.ConsumerMessage, ack func(), done <-chan struct{}) bool {
+	select {
+	case k.messages <- message.Value:
+		ack()
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// putRecord leaves ack to Record.Ack.
+func (k *kafkaSubscriber) putRecord(message *sarama.ConsumerMessage, ack func(), done <-chan struct{}) bool {
+	var record = newKafkaRecord(message)
+	record.ack = ack
+	select {
+	case k.records <- record:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// put delivers message until done, ack commits it, it returns false if done.
+type put func(message *sarama.ConsumerMessage, ack func(), done <-chan struct{}) bool
+
+func (k *kafkaSubscriber) consume(put put) error {
+	if k.group != nil {
+		k.consumeGroup(put)
+		return nil
+	}
+
 	consumer, err := k.consumer.ConsumePartition(k.config.Topic, k.config.PartitionID, sarama.OffsetNewest)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	k.consuming.Add(1)
 	go func() {
+		defer k.consuming.Done()
 		for message := range consumer.Messages() {
 			if k.chanClosed.Load() {
 				break
 			}
 			log.Debug("kafkaSubscriber-get: %s", string(message.Value))
-			k.messages <- message.Value
+			if !put(message, func() {}, k.ctx.Done()) {
+				break
+			}
 		}
 	}()
-	return k.messages, err
+	return nil
 }
 
-func (k *kafkaSubscriber) Run() error {
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Partitioner = sarama.NewRandomPartitioner
-	config.Producer.Return.Successes = true
+// consumeGroup joins the group again after every rebalance until closed.
+func (k *kafkaSubscriber) consumeGroup(put put) {
+	var handler = &groupHandler{subscriber: k, put: put}
+	k.consuming.Add(1)
+	go func() {
+		defer k.consuming.Done()
+		for {
+			if err := k.group.Consume(k.ctx, []string{k.config.Topic}, handler); err != nil {
+				if err == sarama.ErrClosedConsumerGroup {
+					return
+				}
+				log.Error("kafkaSubscriber: consume group,", err)
+				select {
+				case <-time.After(time.Second):
+				case <-k.ctx.Done():
+				}
+			}
+			if k.ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
 
-	consumer, err := sarama.NewConsumer(k.config.Hosts, config)
+func newKafkaRecord(message *sarama.ConsumerMessage) Record {
+	var headers = make(map[string]string, len(message.Headers))
+	for _, h := range message.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	return Record{
+		Key:       message.Key,
+		Data:      message.Value,
+		Headers:   headers,
+		Timestamp: message.Timestamp,
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+	}
+}
+
+func (k *kafkaSubscriber) Run() error {
+	config, err := k.config.Options.NewConfig()
 	if err != nil {
 		return err
 	}
-	k.consumer = consumer
+
+	if k.config.Group.ID != "" {
+		var initial = k.config.Group.Initial
+		if initial.offset == 0 {
+			initial = OffsetNewest
+		}
+		config.Consumer.Offsets.Initial = initial.offset
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{k.config.Group.Rebalance.strategy()}
+
+		client, err := sarama.NewClient(k.config.Hosts, config)
+		if err != nil {
+			return err
+		}
+		group, err := sarama.NewConsumerGroupFromClient(k.config.Group.ID, client)
+		if err != nil {
+			_ = client.Close()
+			return err
+		}
+		k.client, k.group = client, group
+	} else {
+		consumer, err := sarama.NewConsumer(k.config.Hosts, config)
+		if err != nil {
+			return err
+		}
+		k.consumer = consumer
+	}
 
 	go func() {
 		select {
@@ -77,14 +256,23 @@ func (k *kafkaSubscriber) Run() error {
 }
 
 func (k *kafkaSubscriber) Close() error {
-	defer k.cancel()
+	if !k.chanClosed.CompareAndSwap(false, true) {
+		return nil
+	}
+	k.cancel()
 	var err error
-	err = k.consumer.Close()
-	k.chanClosed.Store(true)
+	if k.group != nil {
+		err = k.group.Close()
+		_ = k.client.Close()
+	} else if k.consumer != nil {
+		err = k.consumer.Close()
+	}
+	k.consuming.Wait()
 	close(k.messages)
+	close(k.records)
 
 	log.Debug("kafkaSubscriber: close")
 	return err
 }
 
-var _ Subscribe = &kafkaSubscriber{}
+var _ RecordSubscribe = &kafkaSubscriber{}
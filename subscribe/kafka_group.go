@@ -0,0 +1,96 @@
+package subscribe
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/istomyang/wsevent/log"
+)
+
+// groupHandler consumes claims of a consumer group session, an offset is marked when put acks
+// it and committed by sarama in background.
+type groupHandler struct {
+	subscriber *kafkaSubscriber
+	put        put
+}
+
+var _ sarama.ConsumerGroupHandler = &groupHandler{}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	var config = h.subscriber.config.Group
+	if !config.Initial.at.IsZero() {
+		if err := h.seek(session); err != nil {
+			return err
+		}
+	}
+	log.Debug("kafkaSubscriber: group %s assigned %v", config.ID, session.Claims())
+	if config.OnRebalance != nil {
+		config.OnRebalance(RebalanceEvent{
+			Assigned:     true,
+			Claims:       session.Claims(),
+			MemberID:     session.MemberID(),
+			GenerationID: session.GenerationID(),
+		})
+	}
+	return nil
+}
+
+// seek moves partitions without a committed offset to OffsetAt.
+func (h *groupHandler) seek(session sarama.ConsumerGroupSession) error {
+	var config = h.subscriber.config.Group
+	admin, err := sarama.NewClusterAdminFromClient(h.subscriber.client)
+	if err != nil {
+		return err
+	}
+	// Closing admin would close the shared client.
+	committed, err := admin.ListConsumerGroupOffsets(config.ID, session.Claims())
+	if err != nil {
+		return err
+	}
+
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			if block := committed.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				continue
+			}
+			offset, err := h.subscriber.client.GetOffset(topic, partition, config.Initial.at.UnixMilli())
+			if err != nil {
+				return err
+			}
+			if offset < 0 {
+				continue // No message after it, start from the newest.
+			}
+			session.MarkOffset(topic, partition, offset, "")
+		}
+	}
+	return nil
+}
+
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	var config = h.subscriber.config.Group
+	log.Debug("kafkaSubscriber: group %s revoked %v", config.ID, session.Claims())
+	if config.OnRebalance != nil {
+		config.OnRebalance(RebalanceEvent{
+			Assigned:     false,
+			Claims:       session.Claims(),
+			MemberID:     session.MemberID(),
+			GenerationID: session.GenerationID(),
+		})
+	}
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		log.Debug("kafkaSubscriber-get: %s", string(message.Value))
+		if !h.put(message, markFunc(session, message), session.Context().Done()) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// markFunc marks message consumed when called.
+func markFunc(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) func() {
+	return func() {
+		session.MarkMessage(message, "")
+	}
+}
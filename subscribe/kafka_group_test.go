@@ -0,0 +1,170 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGroupSession records offsets marked in it.
+type fakeGroupSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	marked []string
+	mut    sync.Mutex
+}
+
+func (s *fakeGroupSession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *fakeGroupSession) MemberID() string {
+	return "member-1"
+}
+
+func (s *fakeGroupSession) GenerationID() int32 {
+	return 3
+}
+
+func (s *fakeGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.marked = append(s.marked, fmt.Sprintf("%s/%d/%d", topic, partition, offset))
+}
+
+func (s *fakeGroupSession) Commit() {}
+
+func (s *fakeGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.MarkOffset(topic, partition, offset, metadata)
+}
+
+// MarkMessage marks the offset after message like sarama.
+func (s *fakeGroupSession) MarkMessage(message *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(message.Topic, message.Partition, message.Offset+1, metadata)
+}
+
+func (s *fakeGroupSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeGroupSession) getMarked() string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return fmt.Sprint(s.marked)
+}
+
+type fakeGroupClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeGroupClaim) Topic() string                            { return "topic-test" }
+func (c *fakeGroupClaim) Partition() int32                         { return 0 }
+func (c *fakeGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestGroupHandlerAck(t *testing.T) {
+	var k = NewKafkaSubscriber(context.Background(), KafkaConfig{Topic: "topic-test", Group: KafkaGroupConfig{ID: "group-test"}}).(*kafkaSubscriber)
+	var handler = &groupHandler{subscriber: k, put: k.putRecord}
+	var session = &fakeGroupSession{ctx: context.Background()}
+	var claim = &fakeGroupClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "topic-test", Partition: 0, Offset: 7, Value: []byte("a")}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "topic-test", Partition: 0, Offset: 8, Value: []byte("b")}
+	close(claim.messages)
+
+	var consumed = make(chan error)
+	go func() {
+		consumed <- handler.ConsumeClaim(session, claim)
+	}()
+
+	// An offset is marked after its Record is acked, not when it is delivered.
+	var record = <-k.records
+	if string(record.Data) != "a" || session.getMarked() != "[]" {
+		t.Fatalf("got %s and marked %s before ack", record.Data, session.getMarked())
+	}
+	record.Ack()
+	if got := session.getMarked(); got != "[topic-test/0/8]" {
+		t.Fatalf("marked %s, want [topic-test/0/8]", got)
+	}
+	record = <-k.records
+	record.Ack()
+	if got := session.getMarked(); got != "[topic-test/0/8 topic-test/0/9]" {
+		t.Fatalf("marked %s", got)
+	}
+	if err := <-consumed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupHandlerRebalance(t *testing.T) {
+	var events []RebalanceEvent
+	var k = NewKafkaSubscriber(context.Background(), KafkaConfig{
+		Topic: "topic-test",
+		Group: KafkaGroupConfig{
+			ID: "group-test",
+			OnRebalance: func(event RebalanceEvent) {
+				events = append(events, event)
+			},
+		},
+	}).(*kafkaSubscriber)
+	var handler = &groupHandler{subscriber: k, put: k.putRecord}
+	var session = &fakeGroupSession{ctx: context.Background(), claims: map[string][]int32{"topic-test": {0, 1}}}
+
+	if err := handler.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.Cleanup(session); err != nil {
+		t.Fatal(err)
+	}
+	var got = fmt.Sprint(events)
+	var want = "[{true map[topic-test:[0 1]] member-1 3} {false map[topic-test:[0 1]] member-1 3}]"
+	if got != want {
+		t.Fatalf("got events %s, want %s", got, want)
+	}
+}
+
+func TestGroupHandlerOffsetAt(t *testing.T) {
+	var at = time.Now().Add(-time.Hour)
+	var broker = sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("topic-test", 0, broker.BrokerID()).
+			SetLeader("topic-test", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group-test", broker),
+		// Partition 0 has a committed offset, partition 1 has none.
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group-test", "topic-test", 0, 5, "", sarama.ErrNoError).
+			SetOffset("group-test", "topic-test", 1, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("topic-test", 1, at.UnixMilli(), 42),
+	})
+
+	var config = sarama.NewConfig()
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var k = NewKafkaSubscriber(context.Background(), KafkaConfig{
+		Topic: "topic-test",
+		Group: KafkaGroupConfig{ID: "group-test", Initial: OffsetAt(at)},
+	}).(*kafkaSubscriber)
+	k.client = client
+	var handler = &groupHandler{subscriber: k, put: k.putRecord}
+	var session = &fakeGroupSession{ctx: context.Background(), claims: map[string][]int32{"topic-test": {0, 1}}}
+
+	if err := handler.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.getMarked(); got != "[topic-test/1/42]" {
+		t.Fatalf("marked %s, want [topic-test/1/42]", got)
+	}
+}
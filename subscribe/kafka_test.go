@@ -0,0 +1,30 @@
+package subscribe
+
+import (
+	"github.com/IBM/sarama"
+	"testing"
+	"time"
+)
+
+func TestNewKafkaRecord(t *testing.T) {
+	var at = time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	var record = newKafkaRecord(&sarama.ConsumerMessage{
+		Key:       []byte("order-1"),
+		Value:     []byte("data"),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("tenant"), Value: []byte("mine")}},
+		Timestamp: at,
+		Topic:     "topic-test",
+		Partition: 2,
+		Offset:    42,
+	})
+	if string(record.Key) != "order-1" || string(record.Data) != "data" {
+		t.Fatalf("got key %s data %s", record.Key, record.Data)
+	}
+	if len(record.Headers) != 1 || record.Headers["tenant"] != "mine" {
+		t.Fatalf("got headers %v, want tenant: mine", record.Headers)
+	}
+	if !record.Timestamp.Equal(at) || record.Topic != "topic-test" || record.Partition != 2 || record.Offset != 42 {
+		t.Fatalf("got metadata %v %s %d %d", record.Timestamp, record.Topic, record.Partition, record.Offset)
+	}
+	record.Ack() // No ack outside a consumer group.
+}
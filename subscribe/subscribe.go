@@ -1,5 +1,7 @@
 package subscribe
 
+import "time"
+
 type Subscribe interface {
 	// Get use key to recognize messages what I need.
 	// Note that data is recommended to design into a struct include a string key and a bytes type data.
@@ -7,3 +9,50 @@ type Subscribe interface {
 	Run() error
 	Close() error
 }
+
+// RecordSubscribe is a Subscribe which also provides metadata of messages.
+// Use one of Get and GetRecords, they share the same messages.
+type RecordSubscribe interface {
+	Subscribe
+	// GetRecords is like Get but returns Record s with metadata.
+	GetRecords() (<-chan Record, error)
+}
+
+// Record is a message got from Broker with its metadata.
+type Record struct {
+	Key  []byte
+	Data []byte
+	// Headers are Kafka record headers, for example.
+	Headers   map[string]string
+	Timestamp time.Time
+
+	Topic     string
+	Partition int32
+	Offset    int64
+
+	// ack commits Offset, see Ack.
+	ack func()
+}
+
+// Ack tells Subscribe the record is dispatched, a Kafka consumer group commits its offset then.
+// Records of other Subscribe s need no Ack.
+func (r Record) Ack() {
+	if r.ack != nil {
+		r.ack()
+	}
+}
+
+// Records wraps raw messages into Record s stamped with receive time and numbered by Offset,
+// for Subscribe implementations without metadata.
+func Records(messages <-chan []byte, topic string) <-chan Record {
+	var out = make(chan Record)
+	go func() {
+		defer close(out)
+		var offset int64
+		for data := range messages {
+			out <- Record{Data: data, Timestamp: time.Now(), Topic: topic, Offset: offset}
+			offset++
+		}
+	}()
+	return out
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/istomyang/wsevent/log"
 	"github.com/istomyang/wsevent/ws"
+	"net/url"
 )
 
 type wsSubscriber struct {
@@ -16,14 +17,17 @@ type wsSubscriber struct {
 }
 
 type WsConfig struct {
-	Addr string
-	Path string
+	// URL is a ws:// or wss:// URL, its path is the Topic of Record s.
+	URL string
+
+	// Client configures dialing, like headers, TLS and reconnect, see ws.ClientConfig.
+	Client ws.ClientConfig
 }
 
 func NewWsSubscriber(ctx context.Context, config WsConfig) Subscribe {
 	ctx, cancel := context.WithCancel(ctx)
-	client := ws.NewClient(ctx, ws.ClientConfig{})
-	session, err := client.Create(config.Addr, config.Path)
+	client := ws.NewClient(ctx, config.Client)
+	session, err := client.Create(config.URL)
 	return &wsSubscriber{
 		ctx:     ctx,
 		cancel:  cancel,
@@ -34,9 +38,23 @@ func NewWsSubscriber(ctx context.Context, config WsConfig) Subscribe {
 }
 
 func (w *wsSubscriber) Get() (<-chan []byte, error) {
+	if w.session == nil {
+		return nil, w.err
+	}
 	return w.session.Receive(), nil
 }
 
+func (w *wsSubscriber) GetRecords() (<-chan Record, error) {
+	if w.session == nil {
+		return nil, w.err
+	}
+	var topic string
+	if u, err := url.Parse(w.config.URL); err == nil {
+		topic = u.Path
+	}
+	return Records(w.session.Receive(), topic), nil
+}
+
 func (w *wsSubscriber) Run() error {
 	go func() {
 		select {
@@ -53,10 +71,12 @@ func (w *wsSubscriber) Run() error {
 
 func (w *wsSubscriber) Close() error {
 	defer w.cancel()
-	w.session = nil
+	if w.session != nil {
+		_ = w.session.Close(ws.CloseNormalClosure, "")
+	}
 
 	log.Debug("wsSubscriber: closed")
 	return nil
 }
 
-var _ Subscribe = &wsSubscriber{}
+var _ RecordSubscribe = &wsSubscriber{}
@@ -0,0 +1,20 @@
+package subscribe
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWsSubscriberDialError(t *testing.T) {
+	var sub = NewWsSubscriber(context.Background(), WsConfig{URL: "ws://127.0.0.1:1/topic"})
+	defer sub.Close()
+	if err := sub.Run(); err == nil {
+		t.Fatal("want the dial error from Run")
+	}
+	if _, err := sub.Get(); err == nil {
+		t.Fatal("want the dial error from Get")
+	}
+	if _, err := sub.(RecordSubscribe).GetRecords(); err == nil {
+		t.Fatal("want the dial error from GetRecords")
+	}
+}
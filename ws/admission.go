@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyConnections is returned by Server.Create beyond AdmissionConfig.MaxConnections.
+	ErrTooManyConnections = errors.New("ws: too many connections")
+	// ErrTooManyPerKey is returned by Server.Create beyond AdmissionConfig.MaxPerKey.
+	ErrTooManyPerKey = errors.New("ws: too many connections of a key")
+	// ErrRateLimited is returned by Server.Create beyond AdmissionConfig.Rate.
+	ErrRateLimited = errors.New("ws: upgrade rate limited")
+)
+
+// RemoteIP is the default AdmissionConfig.KeyFunc.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// admission counts Session s of a Server and limits new ones, a slot is released when its Session ends.
+type admission struct {
+	config AdmissionConfig
+
+	total  int
+	perKey map[string]int
+	// tokens is a token bucket refilled by Rate since last.
+	tokens float64
+	last   time.Time
+	mut    sync.Mutex
+}
+
+func newAdmission(config AdmissionConfig) *admission {
+	if config.KeyFunc == nil {
+		config.KeyFunc = RemoteIP
+	}
+	if config.Burst <= 0 {
+		config.Burst = max(int(config.Rate), 1)
+	}
+	return &admission{
+		config: config,
+		perKey: make(map[string]int),
+		tokens: float64(config.Burst),
+		last:   time.Now(),
+	}
+}
+
+// acquire takes a slot for r, the key is for release.
+func (a *admission) acquire(r *http.Request) (string, error) {
+	var key string
+	if a.config.MaxPerKey > 0 {
+		key = a.config.KeyFunc(r)
+	}
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.config.Rate > 0 {
+		var now = time.Now()
+		a.tokens = min(float64(a.config.Burst), a.tokens+now.Sub(a.last).Seconds()*a.config.Rate)
+		a.last = now
+		if a.tokens < 1 {
+			return "", ErrRateLimited
+		}
+		a.tokens--
+	}
+	if a.config.MaxConnections > 0 && a.total >= a.config.MaxConnections {
+		return "", ErrTooManyConnections
+	}
+	if a.config.MaxPerKey > 0 && a.perKey[key] >= a.config.MaxPerKey {
+		return "", ErrTooManyPerKey
+	}
+	a.total++
+	if a.config.MaxPerKey > 0 {
+		a.perKey[key]++
+	}
+	return key, nil
+}
+
+func (a *admission) release(key string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.total--
+	if a.config.MaxPerKey > 0 {
+		if a.perKey[key]--; a.perKey[key] <= 0 {
+			delete(a.perKey, key)
+		}
+	}
+}
+
+// admissionStatus is the HTTP status to reject an upgrade with err.
+func admissionStatus(err error) int {
+	switch err {
+	case ErrRateLimited, ErrTooManyPerKey:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
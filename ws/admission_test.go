@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	var a = newAdmission(AdmissionConfig{
+		MaxConnections: 3,
+		MaxPerKey:      2,
+		KeyFunc:        func(r *http.Request) string { return r.URL.Query().Get("user") },
+		Rate:           1,
+		Burst:          4,
+	})
+	var request = func(user string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/?user="+user, nil)
+	}
+
+	var cases = []struct {
+		user string
+		want error
+	}{
+		{"a", nil},
+		{"a", nil},
+		{"a", ErrTooManyPerKey},
+		{"b", nil},
+		{"c", ErrRateLimited},
+	}
+	for i, c := range cases {
+		if _, err := a.acquire(request(c.user)); err != c.want {
+			t.Fatalf("case %d: got %v, want %v", i, err, c.want)
+		}
+	}
+
+	a.tokens = 4
+	if _, err := a.acquire(request("c")); err != ErrTooManyConnections {
+		t.Fatalf("got %v, want ErrTooManyConnections", err)
+	}
+	a.release("a")
+	if _, err := a.acquire(request("a")); err != nil {
+		t.Fatalf("got %v after release, want nil", err)
+	}
+}
+
+func TestServerAdmission(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{Admission: AdmissionConfig{MaxConnections: 1}})
+	defer server.Close()
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = server.Create(w, r)
+	}))
+	defer ts.Close()
+	var url = "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want 503", err)
+	}
+
+	_ = conn.Close()
+	var deadline = time.Now().Add(time.Second * 5)
+	for {
+		conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot is not released, %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrUnauthorized is returned by a TokenAuthenticator without a token.
+	ErrUnauthorized = errors.New("ws: unauthorized")
+	// ErrForbidden may be returned by AuthConfig.Authorize to refuse keys.
+	ErrForbidden = errors.New("ws: forbidden")
+)
+
+// Principal is who a Session is authenticated as, see Attributes.Principal.
+type Principal struct {
+	ID     string
+	Tenant string
+	Claims map[string]string
+}
+
+// TokenFunc extracts a token from an upgrade request, ok is false without one.
+type TokenFunc func(r *http.Request) (token string, ok bool)
+
+// BearerToken reads "Authorization: Bearer <token>".
+func BearerToken() TokenFunc {
+	return func(r *http.Request) (string, bool) {
+		var header = r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+			return "", false
+		}
+		var token = strings.TrimSpace(header[7:])
+		return token, token != ""
+	}
+}
+
+// CookieToken reads the cookie of name.
+func CookieToken(name string) TokenFunc {
+	return func(r *http.Request) (string, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return cookie.Value, true
+	}
+}
+
+// QueryToken reads the query parameter of name, like a one-time ticket.
+func QueryToken(name string) TokenFunc {
+	return func(r *http.Request) (string, bool) {
+		var token = r.URL.Query().Get(name)
+		return token, token != ""
+	}
+}
+
+// SubprotocolToken reads the subprotocol which starts with prefix, like "token.<token>", as browsers
+// can't set headers. The token must not be chosen by Upgrader.Subprotocols, offer another one with it.
+func SubprotocolToken(prefix string) TokenFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if token, found := strings.CutPrefix(protocol, prefix); found && token != "" {
+				return token, true
+			}
+		}
+		return "", false
+	}
+}
+
+// FirstToken tries fns in order.
+func FirstToken(fns ...TokenFunc) TokenFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, fn := range fns {
+			if token, ok := fn(r); ok {
+				return token, true
+			}
+		}
+		return "", false
+	}
+}
+
+// TokenAuthenticator is an AuthConfig.Authenticate which verifies the token from token.
+func TokenAuthenticator(token TokenFunc, verify func(ctx context.Context, token string) (*Principal, error)) func(r *http.Request) (*Principal, error) {
+	return func(r *http.Request) (*Principal, error) {
+		t, ok := token(r)
+		if !ok {
+			return nil, ErrUnauthorized
+		}
+		return verify(r.Context(), t)
+	}
+}
+
+// Authorize asks AuthConfig.Authorize of the Server of session whether it may subscribe keys,
+// it returns nil for Session s without one.
+func Authorize(session Session, keys []string) error {
+	if a, ok := session.(authorizer); ok {
+		return a.authorize(keys)
+	}
+	return nil
+}
+
+// authorizer is implemented by Session s created by a Server.
+type authorizer interface {
+	authorize(keys []string) error
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenFunc(t *testing.T) {
+	var token = FirstToken(BearerToken(), CookieToken("session"), QueryToken("ticket"), SubprotocolToken("token."))
+
+	var bearer = httptest.NewRequest(http.MethodGet, "/", nil)
+	bearer.Header.Set("Authorization", "bearer t1")
+	var cookie = httptest.NewRequest(http.MethodGet, "/", nil)
+	cookie.AddCookie(&http.Cookie{Name: "session", Value: "t2"})
+	var query = httptest.NewRequest(http.MethodGet, "/?ticket=t3", nil)
+	var subprotocol = httptest.NewRequest(http.MethodGet, "/", nil)
+	subprotocol.Header.Set("Sec-Websocket-Protocol", "wsevent, token.t4")
+
+	var cases = []struct {
+		r    *http.Request
+		want string
+	}{
+		{bearer, "t1"},
+		{cookie, "t2"},
+		{query, "t3"},
+		{subprotocol, "t4"},
+		{httptest.NewRequest(http.MethodGet, "/", nil), ""},
+	}
+	for i, c := range cases {
+		if got, ok := token(c.r); got != c.want || ok != (c.want != "") {
+			t.Errorf("case %d: got %q %v, want %q", i, got, ok, c.want)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{
+		Upgrader: websocket.Upgrader{Subprotocols: []string{"wsevent"}},
+		Auth: AuthConfig{
+			Authenticate: TokenAuthenticator(SubprotocolToken("token."), func(ctx context.Context, token string) (*Principal, error) {
+				if token != "secret" {
+					return nil, ErrForbidden
+				}
+				return &Principal{ID: "42", Tenant: "mine"}, nil
+			}),
+			Authorize: func(session Session, keys []string) error {
+				for _, key := range keys {
+					if !strings.HasPrefix(key, session.Attributes().Principal.Tenant+".") {
+						return ErrForbidden
+					}
+				}
+				return nil
+			},
+		},
+	})
+	defer server.Close()
+	var created = make(chan Session, 1)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if se, err := server.Create(w, r); err == nil {
+			created <- se
+		}
+	}))
+	defer ts.Close()
+	var url = "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	var cases = []struct {
+		protocols []string
+		status    int
+	}{
+		{nil, http.StatusUnauthorized},
+		{[]string{"wsevent", "token.wrong"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		var dialer = websocket.Dialer{Subprotocols: c.protocols}
+		if _, resp, err := dialer.Dial(url, nil); err == nil || resp.StatusCode != c.status {
+			t.Fatalf("got %v, want %d", err, c.status)
+		}
+	}
+
+	var dialer = websocket.Dialer{Subprotocols: []string{"wsevent", "token.secret"}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "wsevent" {
+		t.Fatalf("got subprotocol %q, want wsevent", conn.Subprotocol())
+	}
+
+	var se = <-created
+	if attributes := se.Attributes(); attributes.UserID != "42" || attributes.Tenant != "mine" {
+		t.Fatalf("unexpected attributes %+v", attributes)
+	}
+	if err := Authorize(se, []string{"mine.order.*"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Authorize(se, []string{"mine.order.*", "other.#"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("got %v, want ErrForbidden", err)
+	}
+}
@@ -2,14 +2,18 @@ package ws
 
 import (
 	"context"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/istomyang/wsevent/log"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 // Client manages WebSocket connections in client end.
 type Client interface {
-	// Create creates a connection over a http connection and return a Session object.
-	Create(addr string, path string) (Session, error)
+	// Create dials a ws:// or wss:// URL and return a Session object.
+	Create(url string) (Session, error)
 
 	Run()
 	Close()
@@ -19,32 +23,83 @@ type client struct {
 	ctx      context.Context
 	cancel   context.CancelFunc
 	config   ClientConfig
+	dialer   *websocket.Dialer
 	sessions []innerSession
 }
 
 func NewClient(ctx context.Context, config ClientConfig) Client {
 	ctx, cancel := context.WithCancel(ctx)
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = time.Second * 45
+	}
+	if config.Proxy == nil {
+		config.Proxy = http.ProxyFromEnvironment
+	}
 	return &client{
-		ctx:      ctx,
-		cancel:   cancel,
-		config:   config,
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
+		dialer: &websocket.Dialer{
+			Proxy:             config.Proxy,
+			TLSClientConfig:   config.TLSConfig,
+			HandshakeTimeout:  config.HandshakeTimeout,
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			Subprotocols:      config.Subprotocols,
+			EnableCompression: config.Compression.Enabled,
+			Jar:               config.Jar,
+		},
 		sessions: make([]innerSession, 0), // buffer has data loss when panicked.
 	}
 }
 
-func (c *client) Create(addr string, path string) (Session, error) {
-	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, addr+path, nil)
+func (c *client) Create(rawURL string) (Session, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("ws: bad scheme %q of %s, want ws or wss", u.Scheme, rawURL)
+	}
 
-	var se = newSession(c.ctx, conn, path)
-	se.(innerSession).Attach()
-	c.sessions = append(c.sessions, se.(innerSession))
+	var dial = func(ctx context.Context) (innerSession, error) {
+		conn, resp, err := c.dialer.DialContext(ctx, rawURL, c.config.Header.Clone())
+		if err != nil {
+			return nil, err
+		}
+		var se = newSession(c.ctx, conn, u.Path, sessionConfig{
+			Heartbeat:   c.config.Heartbeat,
+			FrameType:   c.config.FrameType,
+			Compression: c.config.Compression.negotiate(resp.Header),
+		})
+		se.(innerSession).Attach()
+		return se.(innerSession), nil
+	}
+
+	var se innerSession
+	if c.config.Reconnect.Enabled {
+		var rs *reconnectSession
+		rs = newReconnectSession(c.ctx, c.config.Reconnect, dial, func(state State) {
+			if c.config.OnStateChange != nil {
+				c.config.OnStateChange(rs, state)
+			}
+		})
+		if err := rs.connect(); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		rs.Attach()
+		se = rs
+	} else {
+		if se, err = dial(c.ctx); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+	c.sessions = append(c.sessions, se)
 
 	log.Debug("ws-client: create session, %v", se)
-	return se, nil
+	return se.(Session), nil
 }
 
 func (c *client) Run() {
@@ -60,7 +115,7 @@ func (c *client) Run() {
 func (c *client) Close() {
 	defer c.cancel()
 	for _, se := range c.sessions {
-		se.Close()
+		_ = se.terminate(CloseNormalClosure, "", ErrSessionClosed)
 	}
 	log.Debug("ws-client: close")
 }
@@ -79,7 +134,7 @@ func NewFakeClient(config FakeClientConfig) Client {
 	return &fakeClient{config: config}
 }
 
-func (f *fakeClient) Create(addr string, path string) (Session, error) {
+func (f *fakeClient) Create(url string) (Session, error) {
 	log.Debug("ws-fakeClient: create session.")
 	return newFakeSession(FakeSessionConfig{ClientSend: f.config.ClientSend}), nil
 }
@@ -54,8 +54,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestClient(t *testing.T) {
-	var addr = "ws://localhost:8081"
-	var path = "/ws-test"
+	var url = "ws://localhost:8081/ws-test"
 
 	client := NewClient(context.Background(), ClientConfig{})
 	client.Run()
@@ -63,7 +62,7 @@ func TestClient(t *testing.T) {
 
 	go CloseAfter(client, time.Second*20)
 
-	s, err := client.Create(addr, path)
+	s, err := client.Create(url)
 	if err != nil {
 		panic(err)
 	}
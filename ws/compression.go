@@ -0,0 +1,36 @@
+package ws
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+)
+
+// negotiate returns config of a connection, Enabled only if header of Sec-WebSocket-Extensions,
+// of the upgrade request in server or of the response in client, has permessage-deflate.
+func (c CompressionConfig) negotiate(header http.Header) CompressionConfig {
+	if !c.Enabled {
+		return c
+	}
+	if c.Level == 0 {
+		c.Level = 1
+	}
+	c.Enabled = false
+	for _, value := range header.Values("Sec-Websocket-Extensions") {
+		for _, extension := range strings.Split(value, ",") {
+			var name, _, _ = strings.Cut(extension, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				c.Enabled = true
+			}
+		}
+	}
+	return c
+}
+
+// apply sets compression level of conn.
+func (c CompressionConfig) apply(conn *websocket.Conn) error {
+	if !c.Enabled {
+		return nil
+	}
+	return conn.SetCompressionLevel(c.Level)
+}
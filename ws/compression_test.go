@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{
+		Compression: CompressionConfig{Enabled: true, Level: 6, Threshold: 64},
+	})
+	defer server.Close()
+	var created = make(chan Session, 2)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if se, err := server.Create(w, r); err == nil {
+			created <- se
+		}
+	}))
+	defer ts.Close()
+	var url = "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	for _, enabled := range []bool{true, false} {
+		var client = NewClient(context.Background(), ClientConfig{Compression: CompressionConfig{Enabled: enabled}})
+		s, err := client.Create(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var se = <-created
+		if s.Compressed() != enabled || se.Compressed() != enabled {
+			t.Fatalf("got compressed %v and %v, want %v", s.Compressed(), se.Compressed(), enabled)
+		}
+
+		for _, data := range []string{"small", strings.Repeat(`{"status":"ok"}`, 100)} {
+			if err := se.Send([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-s.Receive():
+				if string(got) != data {
+					t.Fatalf("got %d bytes, want %d", len(got), len(data))
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		}
+		client.Close()
+	}
+}
@@ -1,12 +1,127 @@
 package ws
 
 import (
+	"crypto/tls"
 	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 type ServerConfig struct {
-	Upgrader websocket.Upgrader
+	Upgrader  websocket.Upgrader
+	Heartbeat HeartbeatConfig
+	// FrameType is the type of frames Session.Send sends, defaults to BinaryFrame.
+	FrameType FrameType
+
+	// Attributes extracts Attributes of a Session from its upgrade request, Server.Find selects by them.
+	Attributes func(r *http.Request) Attributes
+
+	// Admission rejects upgrades over its limits before Upgrader.Upgrade.
+	Admission AdmissionConfig
+	// Auth authenticates upgrades and authorizes subscriptions of Session s.
+	Auth AuthConfig
+	// Compression enables permessage-deflate when client offers it.
+	Compression CompressionConfig
+
+	// SendBuffer is the number of frames queued per Session for its writer, defaults to 16.
+	// Session.Send waits for room, Server.SendTo and Server.Broadcast never wait for a slow
+	// peer, they drop its frame with ErrSendBufferFull.
+	SendBuffer int
+}
+
+type AuthConfig struct {
+	// Authenticate runs before Upgrader.Upgrade, an error rejects the request with 401 Unauthorized,
+	// or 403 Forbidden for ErrForbidden. See TokenAuthenticator.
+	Authenticate func(r *http.Request) (*Principal, error)
+	// Authorize decides whether session may subscribe keys, see Authorize. Principal of
+	// session is in its Attributes, nil allows all.
+	Authorize func(session Session, keys []string) error
+}
+
+// AdmissionConfig limits upgrades of a Server, zero values disable each limit. Rejected requests
+// get 503 Service Unavailable for MaxConnections, 429 Too Many Requests for the others.
+type AdmissionConfig struct {
+	// MaxConnections is the max number of live Session s.
+	MaxConnections int
+	// MaxPerKey is the max number of live Session s sharing a key from KeyFunc.
+	MaxPerKey int
+	// KeyFunc keys a request for MaxPerKey, like a user ID, defaults to RemoteIP.
+	KeyFunc func(r *http.Request) string
+
+	// Rate is upgrades allowed per second, Burst is how many may come at once, defaults to Rate.
+	Rate  float64
+	Burst int
 }
 
 type ClientConfig struct {
+	// Header is sent with every handshake, like Authorization or Origin.
+	Header http.Header
+	// Jar sends its cookies in handshakes and keeps cookies set by server.
+	Jar http.CookieJar
+	// Subprotocols are offered in order of preference.
+	Subprotocols []string
+	// HandshakeTimeout defaults to 45s.
+	HandshakeTimeout time.Duration
+	// TLSConfig is for wss, like RootCAs of a custom CA or Certificates of client.
+	TLSConfig *tls.Config
+	// Proxy returns the proxy URL of a handshake, or nil for none, defaults to http.ProxyFromEnvironment.
+	Proxy func(r *http.Request) (*url.URL, error)
+	// ReadBufferSize and WriteBufferSize are sizes of I/O buffers, they default to 4096.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	Heartbeat HeartbeatConfig
+	// FrameType is the type of frames Session.Send sends, defaults to BinaryFrame.
+	FrameType FrameType
+
+	// Reconnect makes Session s from Client.Create redial when connection drops.
+	Reconnect ReconnectConfig
+
+	// OnStateChange will call when a reconnecting Session changes its State.
+	OnStateChange func(session Session, state State)
+
+	// Compression offers permessage-deflate to server.
+	Compression CompressionConfig
+}
+
+// CompressionConfig negotiates permessage-deflate of RFC 7692, Session.Compressed reports
+// whether a peer agrees.
+type CompressionConfig struct {
+	Enabled bool
+	// Level is a compress/flate level from 1, best speed, to 9, best compression, defaults to 1.
+	Level int
+	// Threshold is the min size of messages to compress, smaller ones are sent uncompressed.
+	Threshold int
+}
+
+// ReconnectConfig configures exponential backoff between redials,
+// the n-th redial waits min(MinBackoff * 2^n, MaxBackoff) reduced randomly by up to Jitter of it.
+type ReconnectConfig struct {
+	Enabled bool
+
+	// MinBackoff defaults to 500ms.
+	MinBackoff time.Duration
+	// MaxBackoff defaults to 30s.
+	MaxBackoff time.Duration
+	// Jitter is in [0, 1], defaults to 0.2.
+	Jitter float64
+	// MaxAttempts of redials in a row before Session is closed, 0 means unlimited.
+	MaxAttempts int
+}
+
+// HeartbeatConfig detects dead peers and half-open connections, a Session closes itself
+// and reports the reason by Session.Err. Zero values disable each check.
+type HeartbeatConfig struct {
+	// PingInterval is the interval to send pings, it should be less than PongWait.
+	PingInterval time.Duration
+	// PongWait is how long to wait for any frame from peer, pongs included. Frames, pongs too,
+	// are read only after the last message is taken by Session.Receive, so a peer is not
+	// checked while it waits, and PongWait starts over when it is taken.
+	PongWait time.Duration
+	// WriteWait is the deadline to write a frame, set it on a Server, or a stalled peer holds
+	// its Session writing forever, and Session.Send to it waits.
+	WriteWait time.Duration
+	// IdleTimeout closes Session when no message is sent or received for it, pings excluded.
+	IdleTimeout time.Duration
 }
@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"github.com/istomyang/wsevent/log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// replayTimeout limits waiting for server to acknowledge a replayed subscription.
+	replayTimeout = time.Second * 10
+	// eventBuffer is the number of Event s kept for a slow reader of Events.
+	eventBuffer = 256
+)
+
+// ErrControlClosed is returned by requests of Control after its Session ends.
+var ErrControlClosed = errors.New("ws: control session is closed")
+
+// Control speaks the subscription control protocol over a Session in client end,
+// see Envelope. Control reads the Session, use Events instead of Session.Receive.
+// If Session reconnects, see ClientConfig.Reconnect, Control subscribes Keys again.
+type Control interface {
+	// Subscribe adds keys to subscription, it returns when server acknowledges.
+	Subscribe(ctx context.Context, keys ...string) error
+	// Unsubscribe removes keys from subscription.
+	Unsubscribe(ctx context.Context, keys ...string) error
+	// List asks server for subscription.
+	List(ctx context.Context) ([]string, error)
+	Ping(ctx context.Context) error
+
+	// Events returns Event s pushed by server, it is closed when Session ends.
+	// Replies don't wait for Events to be read, the oldest Event is dropped when
+	// the buffer of a slow reader is full, see Overflows.
+	Events() <-chan Event
+	// Overflows returns the number of Event s dropped because the reader was too slow.
+	Overflows() uint64
+	// Keys returns subscription last acknowledged by server.
+	Keys() []string
+}
+
+type control struct {
+	session   Session
+	codec     Codec
+	events    chan Event
+	overflows atomic.Uint64
+
+	nextID  atomic.Uint64
+	pending map[string]chan Envelope
+	keys    []string
+	closed  bool
+	mut     sync.Mutex
+}
+
+// NewControl creates a Control over session, codec defaults to JSONCodec.
+func NewControl(session Session, codec Codec) Control {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	var c = &control{
+		session: session,
+		codec:   codec,
+		events:  make(chan Event, eventBuffer),
+		pending: make(map[string]chan Envelope),
+	}
+	if rs, ok := session.(reconnectNotifier); ok {
+		rs.onReconnect(c.replay)
+	}
+	go c.read()
+	return c
+}
+
+// replay subscribes last acknowledged keys again on a new connection.
+func (c *control) replay() {
+	var keys = c.Keys()
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	if err := c.Subscribe(ctx, keys...); err != nil {
+		log.Error("ws-control: replay subscription,", err)
+	}
+}
+
+func (c *control) read() {
+	defer func() {
+		c.mut.Lock()
+		c.closed = true
+		for id, reply := range c.pending {
+			close(reply)
+			delete(c.pending, id)
+		}
+		c.mut.Unlock()
+		close(c.events)
+	}()
+
+	for data := range c.session.Receive() {
+		var envelope Envelope
+		if err := c.codec.Unmarshal(data, &envelope); err != nil {
+			log.Error("ws-control: unmarshal,", err)
+			continue
+		}
+		if envelope.Op == OpEvent {
+			if envelope.Event != nil {
+				c.push(*envelope.Event)
+			}
+			continue
+		}
+
+		c.mut.Lock()
+		reply, has := c.pending[envelope.ID]
+		delete(c.pending, envelope.ID)
+		c.mut.Unlock()
+		if has {
+			reply <- envelope
+		}
+	}
+}
+
+// push puts event into events without blocking, so replies are still routed to requests
+// while nobody reads Events, the oldest Event is dropped if full.
+func (c *control) push(event Event) {
+	for {
+		select {
+		case c.events <- event:
+			return
+		default:
+		}
+		select {
+		case <-c.events:
+			c.overflows.Add(1)
+			log.Error("ws-control: events overflow, drop the oldest")
+		default:
+		}
+	}
+}
+
+// request sends a request and waits for its reply.
+func (c *control) request(ctx context.Context, op Op, keys []string) (Envelope, error) {
+	var id = strconv.FormatUint(c.nextID.Add(1), 10)
+	var reply = make(chan Envelope, 1)
+
+	c.mut.Lock()
+	if c.closed {
+		c.mut.Unlock()
+		return Envelope{}, ErrControlClosed
+	}
+	c.pending[id] = reply
+	c.mut.Unlock()
+
+	var cancel = func() {
+		c.mut.Lock()
+		delete(c.pending, id)
+		c.mut.Unlock()
+	}
+
+	data, err := c.codec.Marshal(Envelope{ID: id, Op: op, Keys: keys})
+	if err != nil {
+		cancel()
+		return Envelope{}, err
+	}
+	if err := c.session.Send(data); err != nil {
+		cancel()
+		return Envelope{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		cancel()
+		return Envelope{}, ctx.Err()
+	case envelope, ok := <-reply:
+		if !ok {
+			return Envelope{}, ErrControlClosed
+		}
+		if envelope.Op == OpError {
+			return envelope, errors.New(envelope.Error)
+		}
+		return envelope, nil
+	}
+}
+
+func (c *control) update(ctx context.Context, op Op, keys []string) error {
+	envelope, err := c.request(ctx, op, keys)
+	if err != nil {
+		return err
+	}
+	c.mut.Lock()
+	c.keys = envelope.Keys
+	c.mut.Unlock()
+	return nil
+}
+
+func (c *control) Subscribe(ctx context.Context, keys ...string) error {
+	return c.update(ctx, OpSubscribe, keys)
+}
+
+func (c *control) Unsubscribe(ctx context.Context, keys ...string) error {
+	return c.update(ctx, OpUnsubscribe, keys)
+}
+
+func (c *control) List(ctx context.Context) ([]string, error) {
+	if err := c.update(ctx, OpList, nil); err != nil {
+		return nil, err
+	}
+	return c.Keys(), nil
+}
+
+func (c *control) Ping(ctx context.Context) error {
+	_, err := c.request(ctx, OpPing, nil)
+	return err
+}
+
+func (c *control) Events() <-chan Event {
+	return c.events
+}
+
+func (c *control) Overflows() uint64 {
+	return c.overflows.Load()
+}
+
+func (c *control) Keys() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return append([]string(nil), c.keys...)
+}
+
+var _ Control = &control{}
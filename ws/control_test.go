@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlEventsBetweenRequests(t *testing.T) {
+	var upgrader = websocket.Upgrader{}
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var keys []string
+		for {
+			var request Envelope
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			keys = append(keys, request.Keys...)
+			// Push an event of every key before the reply.
+			for _, key := range request.Keys {
+				_ = conn.WriteJSON(Envelope{Op: OpEvent, Event: &Event{Key: key}})
+			}
+			_ = conn.WriteJSON(Envelope{ID: request.ID, Op: OpAck, Keys: keys})
+		}
+	}))
+	defer ts.Close()
+
+	var c = NewClient(context.Background(), ClientConfig{})
+	c.Run()
+	defer c.Close()
+	s, err := c.Create("ws" + strings.TrimPrefix(ts.URL, "http") + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var control = NewControl(s, nil)
+
+	// Events are not read until all requests are acknowledged.
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := control.Subscribe(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := control.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case event := <-control.Events():
+			if event.Key != want {
+				t.Fatalf("got event %s, want %s", event.Key, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+	if n := control.Overflows(); n != 0 {
+		t.Fatalf("got %d overflows, want 0", n)
+	}
+}
@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientDial(t *testing.T) {
+	var upgrader = websocket.Upgrader{Subprotocols: []string{"wsevent"}}
+	var ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(conn.Subprotocol()+" "+r.URL.Path))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	var pool = x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	var client = NewClient(context.Background(), ClientConfig{
+		Header:       http.Header{"Authorization": {"Bearer secret"}},
+		Subprotocols: []string{"wsevent"},
+		TLSConfig:    &tls.Config{RootCAs: pool},
+	})
+	defer client.Close()
+
+	var url = "wss" + strings.TrimPrefix(ts.URL, "https") + "/events"
+	s, err := client.Create(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(<-s.Receive()); got != "wsevent /events" {
+		t.Fatalf("got %q, want subprotocol and path", got)
+	}
+
+	if _, err := client.Create(ts.URL); err == nil {
+		t.Fatal("want an error of https scheme")
+	}
+	if _, err := NewClient(context.Background(), ClientConfig{}).Create(url); err == nil {
+		t.Fatal("want an error of unknown CA")
+	}
+}
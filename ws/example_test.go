@@ -87,14 +87,13 @@ func runSvr() {
 }
 
 func runClient() {
-	var addr = "ws://localhost:8081"
-	var path = "/ws-test"
+	var url = "ws://localhost:8081/ws-test"
 
 	client := NewClient(context.Background(), ClientConfig{})
 	client.Run()
 	defer client.Close()
 
-	s, err := client.Create(addr, path)
+	s, err := client.Create(url)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// FrameType is the type of a data frame.
+type FrameType int
+
+const (
+	// TextFrame carries UTF-8 text like JSON, browsers get it as a string.
+	TextFrame FrameType = websocket.TextMessage
+	// BinaryFrame carries bytes, browsers get it as a Blob or ArrayBuffer.
+	BinaryFrame FrameType = websocket.BinaryMessage
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case TextFrame:
+		return "text"
+	case BinaryFrame:
+		return "binary"
+	}
+	return "unknown"
+}
+
+// Frame is a data frame with its type.
+type Frame struct {
+	Type FrameType
+	Data []byte
+}
+
+// outbound is a Frame to write, or a prepared message of it encoded once for many connections.
+type outbound struct {
+	frame    Frame
+	prepared *websocket.PreparedMessage
+}
+
+// frameData feeds Data of frames to a channel created on first use, so a Session offers
+// both Receive and ReceiveFrames over one stream.
+type frameData struct {
+	once sync.Once
+	out  chan []byte
+}
+
+func (f *frameData) get(frames <-chan Frame, done <-chan struct{}) <-chan []byte {
+	f.once.Do(func() {
+		f.out = make(chan []byte)
+		go func() {
+			defer close(f.out)
+			for frame := range frames {
+				select {
+				case f.out <- frame.Data:
+				case <-done:
+					return
+				}
+			}
+		}()
+	})
+	return f.out
+}
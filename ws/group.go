@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// ErrUnknownSession is returned by Join when Session is not a live one of the Server.
+var ErrUnknownSession = errors.New("ws: unknown session")
+
+// groups are named sets of Session s of a registry, a Session leaves all its groups when it ends.
+type groups struct {
+	registry  *registry
+	frameType FrameType
+
+	members map[string]map[string]Session  // group to Session s by ID.
+	joined  map[string]map[string]struct{} // Session ID to its groups.
+	mut     sync.RWMutex
+}
+
+func newGroups(registry *registry, frameType FrameType) *groups {
+	if frameType == 0 {
+		frameType = BinaryFrame
+	}
+	return &groups{
+		registry:  registry,
+		frameType: frameType,
+		members:   make(map[string]map[string]Session),
+		joined:    make(map[string]map[string]struct{}),
+	}
+}
+
+// Join adds session to group, joining again is a no-op.
+func (g *groups) Join(session Session, group string) error {
+	if _, has := g.registry.Get(session.ID()); !has {
+		return ErrUnknownSession
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if g.members[group] == nil {
+		g.members[group] = make(map[string]Session)
+	}
+	g.members[group][session.ID()] = session
+	if g.joined[session.ID()] == nil {
+		g.joined[session.ID()] = make(map[string]struct{})
+		go func() {
+			<-session.Done()
+			g.leaveAll(session.ID())
+		}()
+	}
+	g.joined[session.ID()][group] = struct{}{}
+	return nil
+}
+
+// Leave removes session from group.
+func (g *groups) Leave(session Session, group string) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.remove(session.ID(), group)
+}
+
+// Members returns Session s in group.
+func (g *groups) Members(group string) []Session {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+	var members = make([]Session, 0, len(g.members[group]))
+	for _, se := range g.members[group] {
+		members = append(members, se)
+	}
+	return members
+}
+
+// Groups returns groups session is in.
+func (g *groups) Groups(session Session) []string {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+	var names = make([]string, 0, len(g.joined[session.ID()]))
+	for name := range g.joined[session.ID()] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Broadcast sends data to members of group, the frame is encoded once for all of them,
+// see fanOut.
+func (g *groups) Broadcast(group string, data []byte) (int, error) {
+	var members = g.Members(group)
+	if len(members) == 0 {
+		return 0, nil
+	}
+	pm, err := websocket.NewPreparedMessage(int(g.frameType), data)
+	if err != nil {
+		return 0, err
+	}
+	return fanOut(members, data, pm)
+}
+
+func (g *groups) leaveAll(id string) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	for group := range g.joined[id] {
+		g.remove(id, group)
+	}
+	delete(g.joined, id)
+}
+
+// remove must be called with mut held.
+func (g *groups) remove(id string, group string) {
+	delete(g.members[group], id)
+	if len(g.members[group]) == 0 {
+		delete(g.members, group)
+	}
+	delete(g.joined[id], group)
+}
@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerBroadcast(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{FrameType: TextFrame})
+	defer server.Close()
+	var created = make(chan Session, 4)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		se, err := server.Create(w, r)
+		if err != nil {
+			return
+		}
+		created <- se
+	}))
+	defer ts.Close()
+
+	var conns []*websocket.Conn
+	var sessions []Session
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		sessions = append(sessions, <-created)
+	}
+
+	for _, se := range sessions[:2] {
+		if err := server.Join(se, "room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Join(newFakeSession(FakeSessionConfig{}), "room"); err != ErrUnknownSession {
+		t.Fatalf("got %v, want ErrUnknownSession", err)
+	}
+
+	sent, err := server.Broadcast("room", []byte("hi"))
+	if sent != 2 || err != nil {
+		t.Fatalf("got %d sent, %v, want 2 sent", sent, err)
+	}
+	for _, conn := range conns[:2] {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if messageType, data, err := conn.ReadMessage(); err != nil || messageType != websocket.TextMessage || string(data) != "hi" {
+			t.Fatalf("got %d %s, %v, want text hi", messageType, data, err)
+		}
+	}
+
+	server.Leave(sessions[0], "room")
+	if got := server.Groups(sessions[0]); len(got) != 0 {
+		t.Fatalf("got groups %v after leave, want none", got)
+	}
+
+	_ = conns[1].Close()
+	var deadline = time.Now().Add(time.Second * 5)
+	for len(server.Members("room")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d members after session ends, want 0", len(server.Members("room")))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServerBroadcastSlowPeer(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{SendBuffer: 1})
+	defer server.Close()
+	var created = make(chan Session, 2)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		se, err := server.Create(w, r)
+		if err != nil {
+			return
+		}
+		created <- se
+	}))
+	defer ts.Close()
+
+	// stalled never reads, healthy reads all.
+	var conns []*websocket.Conn
+	var sessions []Session
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		sessions = append(sessions, <-created)
+		if err := server.Join(sessions[i], "room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stalled, healthy = sessions[0], conns[1]
+	var received = make(chan string, 1024)
+	go func() {
+		for {
+			_, data, err := healthy.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data[:min(len(data), 2)])
+		}
+	}()
+
+	// Frames fill the socket buffers of stalled, then its send buffer, Broadcast drops
+	// its frame instead of waiting.
+	var data = make([]byte, 1<<20)
+	var deadline = time.Now().Add(time.Second * 10)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("Broadcast never dropped a frame of the stalled peer")
+		}
+		_, err := server.Broadcast("room", data)
+		if err != nil && !errors.Is(err, ErrSendBufferFull) {
+			t.Fatal(err)
+		}
+		if err != nil && strings.Contains(err.Error(), stalled.ID()) {
+			break
+		}
+	}
+
+	// healthy still gets frames.
+	time.Sleep(time.Millisecond * 100)
+	if n, _ := server.Broadcast("room", []byte("hi")); n == 0 {
+		t.Fatal("got 0 queued, want the healthy peer")
+	}
+	var timeout = time.After(time.Second * 5)
+	for {
+		select {
+		case got := <-received:
+			if got == "hi" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("healthy peer didn't get the frame")
+		}
+	}
+}
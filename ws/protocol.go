@@ -0,0 +1,133 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Op is the operation of an Envelope.
+type Op string
+
+const (
+	// OpSubscribe adds Keys to subscription of client, server replies OpAck with all keys.
+	OpSubscribe Op = "subscribe"
+	// OpUnsubscribe removes Keys from subscription of client, server replies OpAck with all keys.
+	OpUnsubscribe Op = "unsubscribe"
+	// OpList asks for subscription of client, server replies OpAck with all keys.
+	OpList Op = "list"
+	// OpPing asks server to reply OpPong.
+	OpPing Op = "ping"
+
+	OpAck   Op = "ack"
+	OpError Op = "error"
+	OpPong  Op = "pong"
+	// OpEvent is pushed by server with an Event.
+	OpEvent Op = "event"
+)
+
+// Envelope is a frame of the subscription control protocol between a ws client and server,
+// in JSON, for example:
+//
+//	client: {"id":"1","op":"subscribe","keys":["domain.system.*"]}
+//	server: {"id":"1","op":"ack","keys":["domain.system.*"]}
+//	server: {"op":"event","event":{"key":"domain_system_run","data":{"status":"fail"}}}
+//
+// A reply has the ID of its request.
+type Envelope struct {
+	ID    string   `json:"id,omitempty"`
+	Op    Op       `json:"op"`
+	Keys  []string `json:"keys,omitempty"`
+	Error string   `json:"error,omitempty"`
+	Event *Event   `json:"event,omitempty"`
+}
+
+// Event is a message pushed to client.
+type Event struct {
+	ID        string            `json:"id,omitempty"`
+	Key       string            `json:"key"`
+	Data      Payload           `json:"data,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+}
+
+// Payload is data of Event. In JSON it is embedded as is in "data" when it is a JSON object,
+// array, number, boolean or null kept intact by encoding/json, or else it is in "data_base64",
+// so binary data and JSON strings round trip.
+type Payload []byte
+
+// jsonEvent is Event in JSON, see Payload.
+type jsonEvent struct {
+	ID         string            `json:"id,omitempty"`
+	Key        string            `json:"key"`
+	Data       json.RawMessage   `json:"data,omitempty"`
+	DataBase64 []byte            `json:"data_base64,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Timestamp  *time.Time        `json:"timestamp,omitempty"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	var out = jsonEvent{ID: e.ID, Key: e.Key, Headers: e.Headers}
+	if e.Data.embeddable() {
+		out.Data = json.RawMessage(e.Data)
+	} else if len(e.Data) > 0 {
+		out.DataBase64 = e.Data
+	}
+	if !e.Timestamp.IsZero() {
+		out.Timestamp = &e.Timestamp
+	}
+	return json.Marshal(out)
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var in jsonEvent
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*e = Event{ID: in.ID, Key: in.Key, Headers: in.Headers}
+	if in.DataBase64 != nil {
+		e.Data = Payload(in.DataBase64)
+	} else if len(in.Data) > 0 {
+		e.Data = Payload(in.Data)
+	}
+	if in.Timestamp != nil {
+		e.Timestamp = *in.Timestamp
+	}
+	return nil
+}
+
+// embeddable reports whether p is JSON other than a string that encoding/json writes back
+// byte for byte, as it compacts JSON and escapes HTML characters.
+func (p Payload) embeddable() bool {
+	if len(p) == 0 || p[0] == '"' || !json.Valid(p) {
+		return false
+	}
+	if bytes.ContainsAny(p, "<>&\u2028\u2029") {
+		return false
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, p); err != nil {
+		return false
+	}
+	return bytes.Equal(compacted.Bytes(), p)
+}
+
+// Codec encodes Envelope s into frames, JSONCodec is the default,
+// implement it to speak a binary protocol.
+type Codec interface {
+	Marshal(envelope Envelope) ([]byte, error)
+	Unmarshal(data []byte, envelope *Envelope) error
+}
+
+// JSONCodec encodes Envelope s in JSON.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(envelope Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+func (jsonCodec) Unmarshal(data []byte, envelope *Envelope) error {
+	return json.Unmarshal(data, envelope)
+}
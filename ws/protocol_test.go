@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayload(t *testing.T) {
+	var cases = []struct {
+		data     string
+		embedded bool
+	}{
+		{`{"status":"fail"}`, true},
+		{`[1,2]`, true},
+		{`null`, true},
+		{`plain text`, false},
+		{`"quoted"`, false},
+		{"\xff\x00\x81", false},
+		{`{ "spaced": 1 }`, false},
+		{`{"html":"<b>"}`, false},
+	}
+	for _, c := range cases {
+		encoded, err := JSONCodec.Marshal(Envelope{Op: OpEvent, Event: &Event{Key: "k", Data: Payload(c.data)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(encoded), `"data":`+c.data); got != c.embedded {
+			t.Errorf("%q: got %s, want embedded %v", c.data, encoded, c.embedded)
+		}
+		if strings.Contains(string(encoded), "timestamp") {
+			t.Errorf("%q: got %s, want no zero timestamp", c.data, encoded)
+		}
+		var envelope Envelope
+		if err := JSONCodec.Unmarshal(encoded, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Event == nil || string(envelope.Event.Data) != c.data {
+			t.Fatalf("%q: got %s", c.data, encoded)
+		}
+	}
+
+	var at = time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	encoded, err := JSONCodec.Marshal(Envelope{Op: OpEvent, Event: &Event{Key: "k", Timestamp: at}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope Envelope
+	if err := JSONCodec.Unmarshal(encoded, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if !envelope.Event.Timestamp.Equal(at) || envelope.Event.Data != nil {
+		t.Fatalf("got %+v of %s", envelope.Event, encoded)
+	}
+}
@@ -0,0 +1,301 @@
+package ws
+
+import (
+	"context"
+	"github.com/istomyang/wsevent/log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// State is the connection state of a reconnecting Session.
+type State int
+
+const (
+	StateConnecting State = iota
+	StateConnected
+	// StateReconnecting means connection dropped and Session is redialing.
+	StateReconnecting
+	// StateClosed means Session is closed or gives up redialing.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// reconnectNotifier is implemented by Session s which reconnect, Control uses it to
+// replay its subscription after reconnect.
+type reconnectNotifier interface {
+	onReconnect(fn func())
+}
+
+// reconnectSession redials when its connection drops, Receive keeps the same channel
+// across connections, Send waits for a connection.
+type reconnectSession struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+	config ReconnectConfig
+	// dial creates a connected Session.
+	dial     func(ctx context.Context) (innerSession, error)
+	onChange func(state State)
+
+	frameChan chan Frame
+	receive   frameData
+	current   innerSession
+	connected chan struct{} // closed when current is usable.
+	hooks     []func()
+	// err is the reason of Close, dialErr is the last redial error.
+	err     error
+	dialErr error
+	mut     sync.Mutex
+}
+
+func newReconnectSession(ctx context.Context, config ReconnectConfig,
+	dial func(ctx context.Context) (innerSession, error), onChange func(state State)) *reconnectSession {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Millisecond * 500
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Second * 30
+	}
+	if config.Jitter <= 0 || config.Jitter > 1 {
+		config.Jitter = 0.2
+	}
+	if onChange == nil {
+		onChange = func(State) {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &reconnectSession{
+		id:        newSessionID(),
+		ctx:       ctx,
+		cancel:    cancel,
+		config:    config,
+		dial:      dial,
+		onChange:  onChange,
+		frameChan: make(chan Frame),
+		connected: make(chan struct{}),
+	}
+}
+
+// connect dials the first connection.
+func (r *reconnectSession) connect() error {
+	r.onChange(StateConnecting)
+	se, err := r.dial(r.ctx)
+	if err != nil {
+		r.onChange(StateClosed)
+		return err
+	}
+	r.setCurrent(se)
+	r.onChange(StateConnected)
+	return nil
+}
+
+func (r *reconnectSession) setCurrent(se innerSession) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.current = se
+	if se != nil {
+		close(r.connected)
+	} else {
+		r.connected = make(chan struct{})
+	}
+}
+
+func (r *reconnectSession) Attach() {
+	go func() {
+		defer close(r.frameChan)
+		defer r.onChange(StateClosed)
+		for {
+			r.mut.Lock()
+			var se = r.current
+			r.mut.Unlock()
+
+			for frame := range se.(Session).ReceiveFrames() {
+				select {
+				case r.frameChan <- frame:
+				case <-r.ctx.Done():
+					return
+				}
+			}
+
+			r.setCurrent(nil)
+			select {
+			case <-r.ctx.Done():
+				return
+			default:
+			}
+
+			log.Debug("ws-reconnectSession: connection dropped, reconnecting.")
+			r.onChange(StateReconnecting)
+			if se = r.redial(); se == nil {
+				return
+			}
+			r.setCurrent(se)
+			r.onChange(StateConnected)
+
+			r.mut.Lock()
+			var hooks = append([]func(){}, r.hooks...)
+			r.mut.Unlock()
+			for _, hook := range hooks {
+				go hook()
+			}
+		}
+	}()
+}
+
+// redial dials with backoff until it succeeds, it returns nil when closed or gives up.
+func (r *reconnectSession) redial() innerSession {
+	for attempt := 0; r.config.MaxAttempts == 0 || attempt < r.config.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(r.backoff(attempt)):
+		case <-r.ctx.Done():
+			return nil
+		}
+		se, err := r.dial(r.ctx)
+		if err == nil {
+			return se
+		}
+		r.mut.Lock()
+		r.dialErr = err
+		r.mut.Unlock()
+		log.Debug("ws-reconnectSession: redial,", err)
+	}
+	log.Error("ws-reconnectSession: give up redialing after", r.config.MaxAttempts, "attempts")
+	r.cancel()
+	return nil
+}
+
+func (r *reconnectSession) backoff(attempt int) time.Duration {
+	var d = r.config.MinBackoff
+	for i := 0; i < attempt && d < r.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, r.config.MaxBackoff)
+	return d - time.Duration(rand.Float64()*r.config.Jitter*float64(d))
+}
+
+func (r *reconnectSession) onReconnect(fn func()) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+// ID stays the same across connections.
+func (r *reconnectSession) ID() string {
+	return r.id
+}
+
+func (r *reconnectSession) Attributes() Attributes {
+	return Attributes{}
+}
+
+// Compressed reports it of the current connection.
+func (r *reconnectSession) Compressed() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.current != nil && r.current.(Session).Compressed()
+}
+
+func (r *reconnectSession) Receive() <-chan []byte {
+	return r.receive.get(r.frameChan, r.ctx.Done())
+}
+
+func (r *reconnectSession) ReceiveFrames() <-chan Frame {
+	return r.frameChan
+}
+
+// Send waits for a connection when reconnecting.
+func (r *reconnectSession) Send(data []byte) error {
+	return r.send(func(se Session) error {
+		return se.Send(data)
+	})
+}
+
+// SendFrame waits for a connection when reconnecting.
+func (r *reconnectSession) SendFrame(frame Frame) error {
+	return r.send(func(se Session) error {
+		return se.SendFrame(frame)
+	})
+}
+
+func (r *reconnectSession) send(fn func(se Session) error) error {
+	for {
+		r.mut.Lock()
+		var se, connected = r.current, r.connected
+		r.mut.Unlock()
+
+		if se != nil {
+			if err := fn(se.(Session)); err == nil {
+				return nil
+			}
+		}
+		select {
+		case <-connected:
+			if se != nil {
+				// Send failed on a dropped connection, wait for the next one.
+				time.Sleep(r.config.MinBackoff)
+			}
+		case <-r.ctx.Done():
+			return ErrSessionClosed
+		}
+	}
+}
+
+// Err returns nil while Session is connected or reconnecting, the reason of Close,
+// or the last redial error after it gives up.
+func (r *reconnectSession) Err() error {
+	select {
+	case <-r.ctx.Done():
+	default:
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.dialErr != nil {
+		return r.dialErr
+	}
+	return ErrSessionClosed
+}
+
+func (r *reconnectSession) Close(code int, reason string) error {
+	return r.terminate(code, reason, ErrSessionClosed)
+}
+
+func (r *reconnectSession) terminate(code int, reason string, err error) error {
+	r.mut.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	var se = r.current
+	r.mut.Unlock()
+
+	r.cancel()
+	if se != nil {
+		return se.terminate(code, reason, err)
+	}
+	return nil
+}
+
+// Done is closed when Session is closed or gives up redialing.
+func (r *reconnectSession) Done() <-chan struct{} {
+	return r.ctx.Done()
+}
+
+var _ Session = &reconnectSession{}
+var _ innerSession = &reconnectSession{}
+var _ reconnectNotifier = &reconnectSession{}
@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	var connections atomic.Int32
+	var upgrader = websocket.Upgrader{}
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connections.Add(1)
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+		// Drop the first connection, keep the next one.
+		if connections.Load() == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	var states = make(chan State, 16)
+	var c = NewClient(context.Background(), ClientConfig{
+		Reconnect: ReconnectConfig{
+			Enabled:    true,
+			MinBackoff: time.Millisecond * 10,
+		},
+		OnStateChange: func(session Session, state State) {
+			states <- state
+		},
+	})
+	c.Run()
+	defer c.Close()
+
+	s, err := c.Create("ws" + strings.TrimPrefix(ts.URL, "http") + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-s.Receive():
+			if string(data) != "hello" {
+				t.Fatalf("got %s, want hello", data)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout")
+		}
+	}
+
+	var want = []State{StateConnecting, StateConnected, StateReconnecting, StateConnected}
+	for _, w := range want {
+		if got := <-states; got != w {
+			t.Fatalf("got state %v, want %v", got, w)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// Attributes describe who is on the other end of a Session, see ServerConfig.Attributes.
+type Attributes struct {
+	UserID string
+	Tenant string
+	Labels map[string]string
+	// Principal is from AuthConfig.Authenticate, UserID and Tenant default to its ID and Tenant.
+	Principal *Principal
+}
+
+// Selector picks Session s in a Server.
+type Selector func(session Session) bool
+
+// All selects every Session.
+func All() Selector {
+	return func(Session) bool { return true }
+}
+
+// ByUser selects Session s of a user.
+func ByUser(userID string) Selector {
+	return func(session Session) bool {
+		return session.Attributes().UserID == userID
+	}
+}
+
+// ByTenant selects Session s of a tenant.
+func ByTenant(tenant string) Selector {
+	return func(session Session) bool {
+		return session.Attributes().Tenant == tenant
+	}
+}
+
+// ByLabel selects Session s with label key set to value.
+func ByLabel(key, value string) Selector {
+	return func(session Session) bool {
+		v, has := session.Attributes().Labels[key]
+		return has && v == value
+	}
+}
+
+// newSessionID returns a random unique ID.
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("ws: read random, %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// registry indexes Session s by ID, a Session is removed when it ends.
+type registry struct {
+	sessions map[string]Session
+	mut      sync.RWMutex
+}
+
+func newRegistry() *registry {
+	return &registry{sessions: make(map[string]Session)}
+}
+
+func (r *registry) add(session Session) {
+	r.mut.Lock()
+	r.sessions[session.ID()] = session
+	r.mut.Unlock()
+
+	go func() {
+		<-session.Done()
+		r.mut.Lock()
+		delete(r.sessions, session.ID())
+		r.mut.Unlock()
+	}()
+}
+
+func (r *registry) Get(id string) (Session, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	se, has := r.sessions[id]
+	return se, has
+}
+
+// Range calls fn on a snapshot of Session s, fn returns false to stop.
+func (r *registry) Range(fn func(session Session) bool) {
+	for _, se := range r.snapshot() {
+		if !fn(se) {
+			return
+		}
+	}
+}
+
+func (r *registry) Find(selector Selector) []Session {
+	var found = make([]Session, 0)
+	r.Range(func(se Session) bool {
+		if selector(se) {
+			found = append(found, se)
+		}
+		return true
+	})
+	return found
+}
+
+func (r *registry) Count() int {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return len(r.sessions)
+}
+
+func (r *registry) SendTo(selector Selector, data []byte) (int, error) {
+	return fanOut(r.Find(selector), data, nil)
+}
+
+// queuedSender is implemented by Session s with a send buffer, see ServerConfig.SendBuffer.
+type queuedSender interface {
+	// trySend queues data, or pm if not nil, without waiting, it returns ErrSendBufferFull
+	// when the buffer is full.
+	trySend(data []byte, pm *websocket.PreparedMessage) error
+}
+
+// fanOut queues data to sessions, so a stalled peer drops its frame with ErrSendBufferFull
+// instead of holding up the others, it returns how many are queued and errors of the others.
+func fanOut(sessions []Session, data []byte, pm *websocket.PreparedMessage) (int, error) {
+	var sent int
+	var errs []error
+	for _, se := range sessions {
+		var err error
+		if qs, ok := se.(queuedSender); ok {
+			err = qs.trySend(data, pm)
+		} else {
+			err = se.Send(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", se.ID(), err))
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (r *registry) snapshot() []Session {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	var sessions = make([]Session, 0, len(r.sessions))
+	for _, se := range r.sessions {
+		sessions = append(sessions, se)
+	}
+	return sessions
+}
@@ -0,0 +1,77 @@
+package ws
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerRegistry(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{
+		Attributes: func(r *http.Request) Attributes {
+			return Attributes{UserID: r.URL.Query().Get("user"), Labels: map[string]string{"app": "test"}}
+		},
+	})
+	defer server.Close()
+	var created = make(chan Session, 4)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		se, err := server.Create(w, r)
+		if err != nil {
+			return
+		}
+		created <- se
+	}))
+	defer ts.Close()
+
+	var conns []*websocket.Conn
+	for _, user := range []string{"42", "42", "7"} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/?user="+user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		<-created
+	}
+
+	if n := server.Count(); n != 3 {
+		t.Fatalf("got %d sessions, want 3", n)
+	}
+	if n := len(server.Find(ByLabel("app", "test"))); n != 3 {
+		t.Fatalf("got %d sessions by label, want 3", n)
+	}
+	var sessions = server.Find(ByUser("42"))
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions of user 42, want 2", len(sessions))
+	}
+	if se, ok := server.Get(sessions[0].ID()); !ok || se != sessions[0] {
+		t.Fatal("Get should find session by ID")
+	}
+
+	sent, err := server.SendTo(ByUser("42"), []byte("hi"))
+	if sent != 2 || err != nil {
+		t.Fatalf("got %d sent, %v, want 2 sent", sent, err)
+	}
+	for _, conn := range conns[:2] {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hi" {
+			t.Fatalf("got %s, %v, want hi", data, err)
+		}
+	}
+
+	_ = conns[2].Close()
+	var deadline = time.Now().Add(time.Second * 5)
+	for server.Count() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d sessions after one is closed, want 2", server.Count())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if _, ok := server.Get("unknown"); ok {
+		t.Fatal("want no session of unknown ID")
+	}
+}
@@ -2,16 +2,55 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"github.com/istomyang/wsevent/log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 )
 
+// ErrServerClosed is returned by Server.Create after Shutdown or Close.
+var ErrServerClosed = errors.New("ws: server closed")
+
+// drainer is implemented by Session s which flush pending sends before closing.
+type drainer interface {
+	drain(ctx context.Context, code int, reason string, err error) error
+}
+
 // Server manages WebSocket connections in server end.
 type Server interface {
 	// Create creates a connection over a http connection and return a Session object.
 	Create(w http.ResponseWriter, r *http.Request) (Session, error)
 
+	// Get gets a live Session by its ID.
+	Get(id string) (Session, bool)
+	// Range calls fn on every live Session until fn returns false.
+	Range(fn func(session Session) bool)
+	// Find returns live Session s selected by selector.
+	Find(selector Selector) []Session
+	// Count returns the number of live Session s.
+	Count() int
+	// SendTo queues data to Session s selected by selector without waiting for slow peers,
+	// see ServerConfig.SendBuffer, it returns how many are queued, and errors of the others joined.
+	SendTo(selector Selector, data []byte) (int, error)
+
+	// Join adds a live Session to group, it leaves all groups when it ends.
+	Join(session Session, group string) error
+	// Leave removes Session from group.
+	Leave(session Session, group string)
+	// Members returns Session s in group.
+	Members(group string) []Session
+	// Groups returns groups a Session is in.
+	Groups(session Session) []string
+	// Broadcast queues data to all members of group, like SendTo it returns how many are queued.
+	Broadcast(group string, data []byte) (int, error)
+
 	Run()
+	// Shutdown stops creating Session s, then closes every Session with CloseGoingAway after
+	// its pending sends are written. When ctx ends first, the rest are closed at once and
+	// Shutdown returns ctx.Err(), like http.Server.Shutdown.
+	Shutdown(ctx context.Context) error
+	// Close closes every Session with CloseGoingAway without waiting for pending sends.
 	Close()
 }
 
@@ -20,29 +59,108 @@ type svr struct {
 	cancel context.CancelFunc
 	config ServerConfig
 
-	sessions []innerSession
+	// closed stops Create, mut keeps Create from adding a Session after Shutdown begins,
+	// it is held only to check closed and to add, not across a handshake.
+	closed    bool
+	mut       sync.Mutex
+	admission *admission
+
+	// registry removes a Session when it ends.
+	*registry
+	*groups
 }
 
 func NewServer(ctx context.Context, config ServerConfig) Server {
 	ctx, cancel := context.WithCancel(ctx)
+	if config.Compression.Enabled {
+		config.Upgrader.EnableCompression = true
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = 16
+	}
+	var registry = newRegistry()
 	return &svr{
-		ctx:      ctx,
-		cancel:   cancel,
-		config:   config,
-		sessions: make([]innerSession, 0), // buffer has data loss when panicked.
+		ctx:       ctx,
+		cancel:    cancel,
+		config:    config,
+		registry:  registry,
+		groups:    newGroups(registry, config.FrameType),
+		admission: newAdmission(config.Admission),
 	}
 }
 
 func (s *svr) Create(w http.ResponseWriter, r *http.Request) (Session, error) {
+	if s.isClosed() {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, ErrServerClosed
+	}
+	key, err := s.admission.acquire(r)
+	if err != nil {
+		log.Debug("ws-server: reject upgrade, %v.", err)
+		if err == ErrRateLimited {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, http.StatusText(admissionStatus(err)), admissionStatus(err))
+		return nil, err
+	}
+
+	var principal *Principal
+	if s.config.Auth.Authenticate != nil {
+		if principal, err = s.config.Auth.Authenticate(r); err != nil {
+			s.admission.release(key)
+			log.Debug("ws-server: reject upgrade, %v.", err)
+			var status = http.StatusUnauthorized
+			if errors.Is(err, ErrForbidden) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, http.StatusText(status), status)
+			return nil, err
+		}
+	}
+
 	conn, err := s.config.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		s.admission.release(key)
 		log.Error(err)
 		return nil, err
 	}
 
-	var se = newSession(s.ctx, conn, r.URL.Path)
+	var attributes Attributes
+	if s.config.Attributes != nil {
+		attributes = s.config.Attributes(r)
+	}
+	if principal != nil {
+		attributes.Principal = principal
+		if attributes.UserID == "" {
+			attributes.UserID = principal.ID
+		}
+		if attributes.Tenant == "" {
+			attributes.Tenant = principal.Tenant
+		}
+	}
+	var se = newSession(s.ctx, conn, r.URL.Path, sessionConfig{
+		Heartbeat:   s.config.Heartbeat,
+		FrameType:   s.config.FrameType,
+		Attributes:  attributes,
+		Authorize:   s.config.Auth.Authorize,
+		Compression: s.config.Compression.negotiate(r.Header),
+		SendBuffer:  s.config.SendBuffer,
+	})
 	se.(innerSession).Attach()
-	s.sessions = append(s.sessions, se.(innerSession))
+	go func() {
+		<-se.Done()
+		s.admission.release(key)
+	}()
+
+	// Shutdown may begin during the handshake, it closes the Session s added before.
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		_ = se.(innerSession).terminate(CloseGoingAway, "server shutdown", ErrServerShutdown)
+		return nil, ErrServerClosed
+	}
+	s.add(se)
+	s.mut.Unlock()
 
 	log.Debug("ws-server: create session, %v.", se)
 	return se, nil
@@ -58,18 +176,61 @@ func (s *svr) Run() {
 	}()
 }
 
+func (s *svr) Shutdown(ctx context.Context) error {
+	var expired atomic.Bool
+	s.closeSessions(func(se Session) {
+		if d, ok := se.(drainer); ok {
+			if err := d.drain(ctx, CloseGoingAway, "server shutdown", ErrServerShutdown); err != nil && err == ctx.Err() {
+				expired.Store(true)
+			}
+			return
+		}
+		_ = se.(innerSession).terminate(CloseGoingAway, "server shutdown", ErrServerShutdown)
+	})
+	log.Debug("ws-server: shutdown.")
+	if expired.Load() {
+		return ctx.Err()
+	}
+	return nil
+}
+
 func (s *svr) Close() {
+	s.closeSessions(func(se Session) {
+		_ = se.(innerSession).terminate(CloseGoingAway, "server shutdown", ErrServerShutdown)
+	})
+	log.Debug("ws-server: close.")
+}
+
+func (s *svr) isClosed() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.closed
+}
+
+// closeSessions stops Create and calls fn on every Session in parallel.
+func (s *svr) closeSessions(fn func(se Session)) {
 	defer s.cancel()
-	for _, se := range s.sessions {
-		se.Close()
+	s.mut.Lock()
+	s.closed = true
+	s.mut.Unlock()
+
+	var wg sync.WaitGroup
+	for _, se := range s.snapshot() {
+		wg.Add(1)
+		go func(se Session) {
+			defer wg.Done()
+			fn(se)
+		}(se)
 	}
-	log.Debug("ws-server: close.")
+	wg.Wait()
 }
 
 var _ Server = &svr{}
 
 type fakeServer struct {
 	config FakeServerConfig
+	*registry
+	*groups
 }
 
 type FakeServerConfig struct {
@@ -77,18 +238,26 @@ type FakeServerConfig struct {
 }
 
 func NewFakeServer(config FakeServerConfig) Server {
-	return &fakeServer{config: config}
+	var registry = newRegistry()
+	return &fakeServer{config: config, registry: registry, groups: newGroups(registry, BinaryFrame)}
 }
 
 func (f *fakeServer) Create(w http.ResponseWriter, r *http.Request) (Session, error) {
 	log.Debug("ws-fakeServer: create session.")
-	return newFakeSession(FakeSessionConfig{ClientSend: f.config.ClientSend}), nil
+	var se = newFakeSession(FakeSessionConfig{ClientSend: f.config.ClientSend})
+	f.add(se)
+	return se, nil
 }
 
 func (f *fakeServer) Run() {
 	log.Debug("ws-fakeServer: run.")
 }
 
+func (f *fakeServer) Shutdown(ctx context.Context) error {
+	log.Debug("ws-fakeServer: shutdown.")
+	return nil
+}
+
 func (f *fakeServer) Close() {
 	log.Debug("ws-fakeServer: close.")
 }
@@ -3,141 +3,514 @@ package ws
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/istomyang/wsevent/log"
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
+)
+
+// Close codes of the close frame, see RFC 6455 section 7.4.
+const (
+	CloseNormalClosure     = websocket.CloseNormalClosure
+	CloseGoingAway         = websocket.CloseGoingAway
+	CloseProtocolError     = websocket.CloseProtocolError
+	ClosePolicyViolation   = websocket.ClosePolicyViolation
+	CloseMessageTooBig     = websocket.CloseMessageTooBig
+	CloseInternalServerErr = websocket.CloseInternalServerErr
+	CloseTryAgainLater     = websocket.CloseTryAgainLater
+)
+
+// closeTimeout is how long Close waits for peer to reply the close frame.
+const closeTimeout = time.Second
+
+// CloseError is reported by Session.Err when peer closes Session.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("ws: closed by peer, code %d %s", e.Code, e.Text)
+}
+
+var (
+	// ErrSessionClosed is returned by Send after Session is closed, and by Err if it is closed locally.
+	ErrSessionClosed = errors.New("ws: session is closed")
+	// ErrPongTimeout is reported by Err when peer sends nothing, even pongs, within HeartbeatConfig.PongWait.
+	ErrPongTimeout = errors.New("ws: peer is silent, pong timeout")
+	// ErrIdleTimeout is reported by Err when no message is sent or received within HeartbeatConfig.IdleTimeout.
+	ErrIdleTimeout = errors.New("ws: session is idle")
+	// ErrServerShutdown is reported by Err when Server closes Session.
+	ErrServerShutdown = errors.New("ws: server shutdown")
+	// ErrSendBufferFull is returned for a Session whose peer is too slow by Server.SendTo and
+	// Server.Broadcast, the frame is dropped for it.
+	ErrSendBufferFull = errors.New("ws: send buffer is full")
 )
 
 // Session 's ownership belongs to ws.Client or ws.Server.
 type Session interface {
+	// ID is unique among Session s.
+	ID() string
+	// Attributes are set by ServerConfig.Attributes from the upgrade request.
+	Attributes() Attributes
+	// Compressed reports whether permessage-deflate is negotiated, see CompressionConfig.
+	Compressed() bool
 	// Receive gets message from ws client.
 	Receive() <-chan []byte
-	// Send sends message to ws client.
+	// ReceiveFrames is like Receive with types of frames, use one of Receive and ReceiveFrames.
+	ReceiveFrames() <-chan Frame
+	// Send sends message to ws client in frames of type from config, BinaryFrame by default.
 	Send(data []byte) error
+	// SendFrame sends a message in a frame of its own type.
+	SendFrame(frame Frame) error
+	// Close sends a close frame with code and reason, and waits for peer to reply it
+	// for a while, then closes connection.
+	Close(code int, reason string) error
+	// Done is closed when Session ends.
+	Done() <-chan struct{}
+	// Err returns why Session ended, or nil if it is alive: a *CloseError when peer closes it,
+	// ErrSessionClosed when closed by Close, ErrServerShutdown, ErrPongTimeout,
+	// ErrIdleTimeout, or an error of connection.
+	Err() error
 }
 
 type innerSession interface {
-	Close()
 	// Attach attaches ws into a http connection.
 	Attach()
+	// terminate is Close reporting err as the reason.
+	terminate(code int, reason string, err error) error
+}
+
+// sessionConfig is the part of ServerConfig or ClientConfig a session uses.
+type sessionConfig struct {
+	Heartbeat  HeartbeatConfig
+	FrameType  FrameType
+	Attributes Attributes
+	Authorize  func(session Session, keys []string) error
+	// Compression of the session, Compression.Enabled means it is negotiated.
+	Compression CompressionConfig
+	// SendBuffer is the number of frames queued for the writer.
+	SendBuffer int
 }
 
 // Session holds a chat session context between client and server.
 // You can use Receive and Send functions conveniently.
 type session struct {
-	conn        *websocket.Conn
-	path        string
-	receiveChan chan []byte
-	sendChan    chan []byte
-	closed      atomic.Bool
-	ctx         context.Context
-	cancel      context.CancelFunc
+	id        string
+	conn      *websocket.Conn
+	path      string
+	config    sessionConfig
+	frameChan chan Frame
+	receive   frameData
+	sendChan  chan outbound
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// done is closed when session ends, err is why.
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	// closing is the reason of a local close in handshake, it takes over peer 's reply.
+	closing error
+	// draining refuses new sends, pending counts sends not written yet.
+	draining bool
+	pending  sync.WaitGroup
+	errMut   sync.Mutex
+	// lastActive is UnixNano when a message is sent or received last time.
+	lastActive atomic.Int64
 }
 
 // newSession create a Session.
 // It's recommend to create Session use Client.Create.
-func newSession(ctx context.Context, conn *websocket.Conn, path string) Session {
+func newSession(ctx context.Context, conn *websocket.Conn, path string, config sessionConfig) Session {
 	ctx, cancel := context.WithCancel(ctx)
+	if config.FrameType == 0 {
+		config.FrameType = BinaryFrame
+	}
+	if err := config.Compression.apply(conn); err != nil {
+		log.Error("ws-session: compression,", err)
+	}
 	return &session{
-		ctx:         ctx,
-		cancel:      cancel,
-		conn:        conn,
-		path:        path,
-		receiveChan: make(chan []byte),
-		sendChan:    make(chan []byte),
+		id:        newSessionID(),
+		ctx:       ctx,
+		cancel:    cancel,
+		conn:      conn,
+		path:      path,
+		config:    config,
+		frameChan: make(chan Frame),
+		sendChan:  make(chan outbound, config.SendBuffer),
+		done:      make(chan struct{}),
 	}
 }
 
 var _ Session = &session{}
 var _ innerSession = &session{}
+var _ authorizer = &session{}
+var _ queuedSender = &session{}
 
 func (s *session) Attach() {
+	var hb = s.config.Heartbeat
+	s.lastActive.Store(time.Now().UnixNano())
+
+	if hb.PongWait > 0 {
+		_ = s.conn.SetReadDeadline(time.Now().Add(hb.PongWait))
+		s.conn.SetPongHandler(func(string) error {
+			return s.conn.SetReadDeadline(time.Now().Add(hb.PongWait))
+		})
+	}
+
 	go func() {
 		select {
 		case <-s.ctx.Done():
 			log.Debug("ws-session: closed by context.Done.")
-			s.Close()
+			_ = s.terminate(CloseGoingAway, "", ErrSessionClosed)
+		case <-s.done:
 		}
 	}()
 
 	go func() {
+		defer close(s.frameChan)
 		for {
 			messageType, data, err := s.conn.ReadMessage()
 			if err != nil {
-				log.Error(err)
-				break
+				var ne net.Error
+				var ce *websocket.CloseError
+				if errors.As(err, &ce) {
+					err = &CloseError{Code: ce.Code, Text: ce.Text}
+				} else if errors.As(err, &ne) && ne.Timeout() {
+					err = ErrPongTimeout
+				}
+				s.closeWithError(err)
+				return
 			}
 			log.Debug("ws-session: conn.ReadMessage, %v", string(data))
-			if messageType == websocket.CloseMessage {
-				break
+			s.lastActive.Store(time.Now().UnixNano())
+			select {
+			case s.frameChan <- Frame{Type: FrameType(messageType), Data: data}:
+			case <-s.done:
+				return
+			}
+			// Pongs are not read while waiting for the application, so PongWait starts over.
+			if hb.PongWait > 0 {
+				_ = s.conn.SetReadDeadline(time.Now().Add(hb.PongWait))
 			}
-			s.receiveChan <- data
 		}
 	}()
 
 	go func() {
-		for send := range s.sendChan {
-			if err := s.conn.WriteMessage(websocket.BinaryMessage, send); err != nil {
-				log.Error(err)
-				break
+		var ping, idle <-chan time.Time
+		if hb.PingInterval > 0 {
+			var ticker = time.NewTicker(hb.PingInterval)
+			defer ticker.Stop()
+			ping = ticker.C
+		}
+		if hb.IdleTimeout > 0 {
+			var ticker = time.NewTicker(max(hb.IdleTimeout/4, time.Millisecond))
+			defer ticker.Stop()
+			idle = ticker.C
+		}
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case send := <-s.sendChan:
+				s.setWriteDeadline()
+				if s.config.Compression.Enabled {
+					s.conn.EnableWriteCompression(len(send.frame.Data) >= s.config.Compression.Threshold)
+				}
+				var err error
+				if send.prepared != nil {
+					err = s.conn.WritePreparedMessage(send.prepared)
+				} else {
+					err = s.conn.WriteMessage(int(send.frame.Type), send.frame.Data)
+				}
+				s.pending.Done()
+				if err != nil {
+					s.closeWithError(err)
+					return
+				}
+				s.lastActive.Store(time.Now().UnixNano())
+				log.Debug("ws-session: conn.WriteMessage, %v", string(send.frame.Data))
+			case <-ping:
+				s.setWriteDeadline()
+				if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					s.closeWithError(err)
+					return
+				}
+			case now := <-idle:
+				if now.Sub(time.Unix(0, s.lastActive.Load())) > hb.IdleTimeout {
+					_ = s.terminate(CloseNormalClosure, "idle timeout", ErrIdleTimeout)
+					return
+				}
 			}
-			log.Debug("ws-session: conn.WriteMessage, %v", string(send))
 		}
 	}()
 }
 
-func (s *session) Close() {
-	if s.closed.Load() {
-		return
+func (s *session) setWriteDeadline() {
+	if s.config.Heartbeat.WriteWait > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.config.Heartbeat.WriteWait))
+	}
+}
+
+func (s *session) Close(code int, reason string) error {
+	return s.terminate(code, reason, ErrSessionClosed)
+}
+
+func (s *session) terminate(code int, reason string, err error) error {
+	s.errMut.Lock()
+	if s.closing != nil || s.err != nil {
+		s.errMut.Unlock()
+		<-s.done
+		return nil
+	}
+	s.closing = err
+	s.errMut.Unlock()
+
+	var werr = s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(closeTimeout))
+	if werr == nil {
+		// Reader ends session when peer replies.
+		select {
+		case <-s.done:
+		case <-time.After(closeTimeout):
+		}
 	}
-	s.closed.Store(true)
+	s.closeWithError(err)
+	return werr
+}
+
+// closeWithError ends session with err as the reason, only the first reason is kept.
+func (s *session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.errMut.Lock()
+		if s.closing != nil {
+			err = s.closing
+		}
+		if err == nil {
+			err = ErrSessionClosed
+		}
+		s.err = err
+		s.errMut.Unlock()
+
+		close(s.done)
+		s.cancel()
+		_ = s.conn.Close()
 
-	defer s.cancel()
+		if err != ErrSessionClosed {
+			log.Info("ws-session: close,", err)
+		} else {
+			log.Debug("ws-session: close")
+		}
+	})
+}
+
+func (s *session) ID() string {
+	return s.id
+}
+
+func (s *session) Attributes() Attributes {
+	return s.config.Attributes
+}
 
-	close(s.sendChan)
-	close(s.receiveChan)
+func (s *session) Compressed() bool {
+	return s.config.Compression.Enabled
+}
 
-	log.Debug("ws-session: close")
+func (s *session) authorize(keys []string) error {
+	if s.config.Authorize == nil {
+		return nil
+	}
+	return s.config.Authorize(s, keys)
 }
 
 func (s *session) Receive() <-chan []byte {
 	// Read closed can't cause panic
-	return s.receiveChan
+	return s.receive.get(s.frameChan, s.done)
+}
+
+func (s *session) ReceiveFrames() <-chan Frame {
+	return s.frameChan
 }
 
 func (s *session) Send(data []byte) error {
-	// Put data into closed channel cause panic.
-	// The reason for using atomic instead of scalar type is not ensure happens-before in concurrency.
-	if s.closed.Load() {
-		return errors.New("session is closed")
+	return s.SendFrame(Frame{Type: s.config.FrameType, Data: data})
+}
+
+func (s *session) SendFrame(frame Frame) error {
+	if err := s.send(outbound{frame: frame}, true); err != nil {
+		return err
 	}
-	s.sendChan <- data
+	log.Debug("ws-session: Send, %v", string(frame.Data))
+	return nil
+}
 
+func (s *session) trySend(data []byte, pm *websocket.PreparedMessage) error {
+	var send = outbound{frame: Frame{Type: s.config.FrameType, Data: data}, prepared: pm}
+	if err := s.send(send, false); err != nil {
+		return err
+	}
 	log.Debug("ws-session: Send, %v", string(data))
 	return nil
 }
 
+// send queues send for the writer, it waits for room in sendChan if wait.
+func (s *session) send(send outbound, wait bool) error {
+	s.errMut.Lock()
+	if s.draining {
+		s.errMut.Unlock()
+		return ErrSessionClosed
+	}
+	s.pending.Add(1)
+	s.errMut.Unlock()
+
+	select {
+	case <-s.done:
+		s.pending.Done()
+		return ErrSessionClosed
+	default:
+	}
+	if !wait {
+		select {
+		case s.sendChan <- send:
+			return nil
+		default:
+			s.pending.Done()
+			return ErrSendBufferFull
+		}
+	}
+	select {
+	case s.sendChan <- send:
+		return nil
+	case <-s.done:
+		s.pending.Done()
+		return ErrSessionClosed
+	}
+}
+
+// drain refuses new sends, waits for pending sends to be written, then closes like terminate.
+// When ctx ends first, it closes connection at once and returns ctx.Err().
+func (s *session) drain(ctx context.Context, code int, reason string, err error) error {
+	s.errMut.Lock()
+	s.draining = true
+	s.errMut.Unlock()
+
+	var flushed = make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-s.done:
+		// Frames left in sendChan are never written.
+		return nil
+	case <-ctx.Done():
+		s.closeWithError(err)
+		return ctx.Err()
+	}
+
+	var terminated = make(chan error, 1)
+	go func() {
+		terminated <- s.terminate(code, reason, err)
+	}()
+	select {
+	case werr := <-terminated:
+		return werr
+	case <-ctx.Done():
+		s.closeWithError(err)
+		return ctx.Err()
+	}
+}
+
+func (s *session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *session) Err() error {
+	s.errMut.Lock()
+	defer s.errMut.Unlock()
+	return s.err
+}
+
 type fakeSession struct {
-	config FakeSessionConfig
+	id        string
+	config    FakeSessionConfig
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 type FakeSessionConfig struct {
 	ClientSend <-chan []byte
+	Attributes Attributes
 }
 
 func newFakeSession(config FakeSessionConfig) Session {
 	return &fakeSession{
+		id:     newSessionID(),
 		config: config,
+		done:   make(chan struct{}),
 	}
 }
 
+func (f *fakeSession) ID() string {
+	return f.id
+}
+
+func (f *fakeSession) Attributes() Attributes {
+	return f.config.Attributes
+}
+
+func (f *fakeSession) Compressed() bool {
+	return false
+}
+
 func (f *fakeSession) Receive() <-chan []byte {
 	return f.config.ClientSend
 }
 
+func (f *fakeSession) ReceiveFrames() <-chan Frame {
+	var frames = make(chan Frame)
+	go func() {
+		defer close(frames)
+		for data := range f.config.ClientSend {
+			frames <- Frame{Type: BinaryFrame, Data: data}
+		}
+	}()
+	return frames
+}
+
 func (f *fakeSession) Send(data []byte) error {
 	log.Debug("session-send: %s", string(data))
 	return nil // discard
 }
 
+func (f *fakeSession) SendFrame(frame Frame) error {
+	return f.Send(frame.Data)
+}
+
+func (f *fakeSession) Close(code int, reason string) error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+	return nil
+}
+
+func (f *fakeSession) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *fakeSession) Err() error {
+	select {
+	case <-f.done:
+		return ErrSessionClosed
+	default:
+		return nil
+	}
+}
+
 var _ Session = &fakeSession{}
@@ -0,0 +1,184 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer runs handler on every upgraded connection, it returns a ws:// URL.
+func newTestServer(t *testing.T, handler func(conn *websocket.Conn)) string {
+	var upgrader = websocket.Upgrader{}
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func waitEnd(t *testing.T, s Session) error {
+	select {
+	case _, ok := <-s.Receive():
+		if ok {
+			t.Fatal("want no message")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	return s.Err()
+}
+
+func TestSessionHeartbeat(t *testing.T) {
+	var silent = newTestServer(t, func(conn *websocket.Conn) {
+		// Never read, so pings are never answered.
+		time.Sleep(time.Second * 2)
+	})
+	var alive = newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	var cases = []struct {
+		url       string
+		heartbeat HeartbeatConfig
+		want      error
+	}{
+		{silent, HeartbeatConfig{PingInterval: time.Millisecond * 50, PongWait: time.Millisecond * 200}, ErrPongTimeout},
+		{alive, HeartbeatConfig{PingInterval: time.Millisecond * 50, PongWait: time.Millisecond * 200, IdleTimeout: time.Millisecond * 400}, ErrIdleTimeout},
+	}
+
+	for _, c := range cases {
+		var client = NewClient(context.Background(), ClientConfig{Heartbeat: c.heartbeat})
+		s, err := client.Create(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := waitEnd(t, s); got != c.want {
+			t.Errorf("got %v, want %v", got, c.want)
+		}
+		if err := s.Send([]byte("late")); err != ErrSessionClosed {
+			t.Errorf("got %v, want ErrSessionClosed", err)
+		}
+		client.Close()
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	var peerCode = make(chan int, 1)
+	var url = newTestServer(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok {
+				peerCode <- ce.Code
+			}
+			return
+		}
+		// Close from server after the first message.
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "kick"), time.Now().Add(time.Second))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	var client = NewClient(context.Background(), ClientConfig{})
+	defer client.Close()
+
+	s, err := client.Create(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(CloseNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-peerCode; got != CloseNormalClosure {
+		t.Fatalf("peer got code %d, want %d", got, CloseNormalClosure)
+	}
+	<-s.Done()
+	if s.Err() != ErrSessionClosed {
+		t.Fatalf("got %v, want ErrSessionClosed", s.Err())
+	}
+
+	s, err = client.Create(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Send([]byte("hello"))
+	var ce *CloseError
+	if err := waitEnd(t, s); !errors.As(err, &ce) || ce.Code != 4000 || ce.Text != "kick" {
+		t.Fatalf("got %v, want CloseError 4000 kick", err)
+	}
+}
+
+func TestSessionFrames(t *testing.T) {
+	var url = newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(messageType, data)
+		}
+	})
+
+	var client = NewClient(context.Background(), ClientConfig{FrameType: TextFrame})
+	defer client.Close()
+	s, err := client.Create(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = s.Send([]byte(`{"op":"ping"}`))
+	_ = s.SendFrame(Frame{Type: BinaryFrame, Data: []byte{0x1}})
+
+	var frames = s.ReceiveFrames()
+	for _, want := range []FrameType{TextFrame, BinaryFrame} {
+		select {
+		case frame := <-frames:
+			if frame.Type != want {
+				t.Fatalf("got %v frame, want %v", frame.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestSessionHeartbeatUnread(t *testing.T) {
+	var url = newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+		// Read answers pings.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	var client = NewClient(context.Background(), ClientConfig{
+		Heartbeat: HeartbeatConfig{PingInterval: time.Millisecond * 50, PongWait: time.Millisecond * 200},
+	})
+	defer client.Close()
+	s, err := client.Create(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A message not taken for longer than PongWait doesn't time out a live peer.
+	time.Sleep(time.Millisecond * 500)
+	if data := <-s.Receive(); string(data) != "hello" {
+		t.Fatalf("got %s, want hello", data)
+	}
+	time.Sleep(time.Millisecond * 400)
+	if err := s.Err(); err != nil {
+		t.Fatalf("got %v, want a live session", err)
+	}
+}
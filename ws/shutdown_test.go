@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	var server = NewServer(context.Background(), ServerConfig{})
+	var created = make(chan Session, 1)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		se, err := server.Create(w, r)
+		if err != nil {
+			return
+		}
+		created <- se
+	}))
+	defer ts.Close()
+	var url = "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var se = <-created
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = se.Send([]byte("pending"))
+		}()
+	}
+	wg.Wait()
+
+	var received = make(chan int, 1)
+	go func() {
+		var n int
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if websocket.IsCloseError(err, CloseGoingAway) {
+					received <- n
+				} else {
+					received <- -1
+				}
+				return
+			}
+			n++
+		}
+	}()
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-received; n != 5 {
+		t.Fatalf("got %d messages before going away, want 5", n)
+	}
+	if se.Err() != ErrServerShutdown {
+		t.Fatalf("got %v, want ErrServerShutdown", se.Err())
+	}
+	if err := se.Send([]byte("late")); err != ErrSessionClosed {
+		t.Fatalf("got %v, want ErrSessionClosed", err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want 503 after shutdown", err)
+	}
+}
+
+func TestServerShutdownDuringHandshake(t *testing.T) {
+	var entered, release = make(chan struct{}), make(chan struct{})
+	var server = NewServer(context.Background(), ServerConfig{
+		Auth: AuthConfig{
+			Authenticate: func(r *http.Request) (*Principal, error) {
+				close(entered)
+				<-release
+				return &Principal{ID: "u1"}, nil
+			},
+		},
+	})
+	var created = make(chan error, 1)
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := server.Create(w, r)
+		created <- err
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	var dialed = make(chan error, 1)
+	go func() {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			dialed <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = conn.ReadMessage()
+		dialed <- err
+	}()
+	<-entered
+
+	// A slow handshake doesn't hold Shutdown.
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handshake finishing after Shutdown gets its Session closed.
+	release <- struct{}{}
+	if err := <-created; err != ErrServerClosed {
+		t.Fatalf("got %v, want ErrServerClosed", err)
+	}
+	if err := <-dialed; !websocket.IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("got %v, want CloseGoingAway", err)
+	}
+}